@@ -0,0 +1,382 @@
+package gopiper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+)
+
+// Pipeline is a PipeItem compiled once for repeated use: rule types, filter
+// chains, regexps and selectors are checked and parsed by Compile, so the Run
+// methods only walk the page. A Pipeline is never modified after Compile and
+// may be run from many goroutines at the same time. Filters are bound when the
+// rule is compiled; ReplaceFilter does not affect existing pipelines.
+type Pipeline struct {
+	root *pipeNode
+}
+
+// pipeNode is the compiled form of one PipeItem of the rule tree.
+type pipeNode struct {
+	name      string
+	selector  string
+	tp        string
+	path      string
+	rule      *PipeItem
+	attr      string // attribute name of the attr[x] type
+	attrArray string // attribute name of the attr-array[x] type
+	filters   filterchain
+	exp       *regexp.Regexp // regexp: and regexpall: selectors, alone or after a node selector
+	expAll    bool           // regexpall: takes every match, not the first
+	groups    bool           // exp has named groups
+
+	// a selector means something different to each page type, so every
+	// dialect is parsed up front and its error reported only when a page of
+	// that type is run
+	html     *htmlpipe
+	htmlErr  error
+	json     *jsonpath
+	jsonErr  error
+	xpath    *xpath.Expr
+	xpathErr error
+	text     *textpipe
+	textErr  error
+
+	required  bool
+	def       interface{}
+	omitempty bool
+	absurl    bool
+
+	subs []*pipeNode
+}
+
+// Compile validates the rule tree and parses its selectors, filters and
+// regexps into a reusable Pipeline.
+func Compile(item PipeItem) (*Pipeline, error) {
+	root, err := compileNode(&item, "$")
+	if err != nil {
+		return nil, err
+	}
+	return &Pipeline{root}, nil
+}
+
+// CompileFor is Compile for rules that only run over one page type: the
+// selectors are also checked in its dialect, so an unknown html function
+// such as `li|foo` is an error here rather than when the rule runs.
+func CompileFor(item PipeItem, pagetype string) (*Pipeline, error) {
+	pl, err := Compile(item)
+	if err != nil {
+		return nil, err
+	}
+	if err = pl.root.checkDialect(pagetype); err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
+
+// checkDialect returns the selector error of n or its sub nodes for
+// pagetype. The sub nodes of jsonparse read json whatever the page is.
+func (n *pipeNode) checkDialect(pagetype string) error {
+	var err error
+	switch pagetype {
+	case PAGE_HTML:
+		err = n.htmlErr
+	case PAGE_JSON, PAGE_JS:
+		err = n.jsonErr
+	case PAGE_XML:
+		err = n.xpathErr
+	case PAGE_TEXT:
+		err = n.textErr
+	default:
+		return &RuleError{Value: pagetype, Msg: "unknown page type"}
+	}
+	if err != nil {
+		return err
+	}
+	if n.tp == PT_JSON_PARSE {
+		pagetype = PAGE_JSON
+	}
+	for _, sub := range n.subs {
+		if err := sub.checkDialect(pagetype); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MustCompile is like Compile but panics if the rule can not be compiled.
+func MustCompile(item PipeItem) *Pipeline {
+	pl, err := Compile(item)
+	if err != nil {
+		panic(err)
+	}
+	return pl
+}
+
+func compileNode(p *PipeItem, path string) (*pipeNode, error) {
+	rule := *p
+	n := &pipeNode{
+		rule:      &rule,
+		name:      p.Name,
+		selector:  p.Selector,
+		tp:        p.Type,
+		path:      path,
+		required:  p.Required,
+		omitempty: p.OmitEmpty,
+		absurl:    p.AbsUrl,
+	}
+
+	switch {
+	case p.Type == "" && p.Selector != "":
+		return nil, missingTypeError(path, p.Selector)
+	case isConstType(p.Type), known_types[p.Type]:
+	case attr_type_exp.MatchString(p.Type):
+		n.attr = attr_type_exp.FindStringSubmatch(p.Type)[1]
+	case attr_array_type_exp.MatchString(p.Type):
+		n.attrArray = attr_array_type_exp.FindStringSubmatch(p.Type)[1]
+	default:
+		return nil, unknownTypeError(path, p.Type)
+	}
+
+	def, err := typedDefault(p.Default, p.Type)
+	if err != nil {
+		return nil, &RuleError{Path: path + ".default", Value: fmt.Sprint(p.Default), Msg: err.Error()}
+	}
+	n.def = def
+
+	if p.AbsUrl && !absurl_types[p.Type] && n.attr == "" && n.attrArray == "" {
+		return nil, &RuleError{Path: path + ".absurl", Value: p.Type, Msg: "absurl needs a href, src, attr or string type"}
+	}
+
+	filters, err := compileFilter(p.Filter)
+	if err != nil {
+		return nil, &RuleError{Path: path + ".filter", Value: p.Filter, Msg: err.Error()}
+	}
+	n.filters = filters
+
+	if !isConstType(p.Type) {
+		selector, expsel := splitRegexpSelector(p.Selector)
+		expr, all, isexp, err := parseRegexpSelector(expsel)
+		if err != nil {
+			return nil, selectorError(path, p.Selector, err)
+		}
+		if isexp {
+			n.expAll = all
+			n.exp, err = compileRegexp(expr)
+			if err != nil {
+				return nil, selectorError(path, p.Selector, err)
+			}
+			for _, name := range n.exp.SubexpNames() {
+				n.groups = n.groups || name != ""
+			}
+		}
+		if selector != "" {
+			n.html, err = compileHtmlSelector(selector)
+			if err != nil {
+				n.htmlErr = selectorError(path, p.Selector, err)
+			}
+			n.json, err = compileJsonSelector(selector)
+			if err != nil {
+				n.jsonErr = selectorError(path, p.Selector, err)
+			}
+			n.xpath, err = xpath.Compile(strings.TrimPrefix(selector, "xpath:"))
+			if err != nil {
+				n.xpathErr = selectorError(path, p.Selector, err)
+			}
+			n.text, err = compileTextSelector(selector)
+			if err != nil {
+				n.textErr = selectorError(path, p.Selector, err)
+			}
+		}
+	}
+
+	switch p.Type {
+	case PT_MAP, PT_ARRAY, PT_JSON_PARSE:
+		// named regexp groups are map keys of their own
+		if len(p.SubItem) == 0 && !(n.groups && p.Type != PT_JSON_PARSE) {
+			return nil, &RuleError{Path: path + ".subitem", Value: p.Type, Msg: "pipe type need one subItem"}
+		}
+	}
+
+	n.subs = make([]*pipeNode, 0, len(p.SubItem))
+	for i := range p.SubItem {
+		sub, err := compileNode(&p.SubItem[i], subpath(path, i))
+		if err != nil {
+			return nil, err
+		}
+		n.subs = append(n.subs, sub)
+	}
+	return n, nil
+}
+
+// typedDefault converts the scalar default of an int, float or bool rule to
+// the rule type, so that a json "default": 0 of an int rule is an int64 like
+// a real match. Other defaults are copied as they are.
+func typedDefault(def interface{}, tp string) (interface{}, error) {
+	if def == nil {
+		return nil, nil
+	}
+	v := reflect.ValueOf(def)
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Ptr, reflect.Struct:
+		return copyValue(def), nil
+	}
+
+	switch tp {
+	case PT_INT:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return v.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return int64(v.Uint()), nil
+		case reflect.Float32, reflect.Float64:
+			if f := v.Float(); f == math.Trunc(f) {
+				return int64(f), nil
+			}
+		case reflect.String:
+			return parseInteger(v.String(), default_number_format)
+		}
+	case PT_FLOAT:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(v.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return float64(v.Uint()), nil
+		case reflect.Float32, reflect.Float64:
+			return v.Float(), nil
+		case reflect.String:
+			f, _, err := parseNumber(v.String(), default_number_format)
+			return f, err
+		}
+	case PT_BOOL:
+		switch v.Kind() {
+		case reflect.Bool:
+			return v.Bool(), nil
+		case reflect.String:
+			return strconv.ParseBool(v.String())
+		}
+	default:
+		return def, nil
+	}
+	return nil, errors.New("default is not a " + tp + ": " + v.Type().String())
+}
+
+func selectorError(path, selector string, err error) error {
+	return &RuleError{Path: path + ".selector", Value: selector, Msg: err.Error()}
+}
+
+// RunBytes parses body as pagetype and runs the pipeline over it. Fields
+// that fail are left nil; use Extract to find out why.
+func (pl *Pipeline) RunBytes(body []byte, pagetype string) (interface{}, error) {
+	return pl.run(newRunContext(Options{}), body, pagetype)
+}
+
+// Extract is RunBytes with a Report of every failure next to the partial
+// result. The error is set when nothing could be extracted, or on the first
+// failure in strict mode.
+func (pl *Pipeline) Extract(body []byte, pagetype string, opt Options) (interface{}, *Report, error) {
+	ctx := newRunContext(opt)
+	val, err := pl.run(ctx, body, pagetype)
+	return val, ctx.report, err
+}
+
+func (pl *Pipeline) run(ctx *runContext, body []byte, pagetype string) (interface{}, error) {
+	var (
+		val interface{}
+		err error
+	)
+
+	ctx.pagetype = pagetype
+	if err = ctx.setBase(ctx.BaseURL); err != nil {
+		return nil, err
+	}
+	switch pagetype {
+	case PAGE_HTML:
+		doc, perr := goquery.NewDocumentFromReader(bytes.NewReader(body))
+		if perr != nil {
+			return nil, perr
+		}
+		ctx.setDocumentBase(doc)
+		val, err = pl.root.pipeSelection(ctx, doc.Selection)
+	case PAGE_JSON:
+		val, err = pl.root.pipeJson(ctx, body)
+	case PAGE_TEXT:
+		val, err = pl.root.pipeText(ctx, body)
+	case PAGE_XML:
+		doc, perr := parseXmlDocument(body)
+		if perr != nil {
+			return nil, perr
+		}
+		val, err = pl.root.pipeXml(ctx, []*xmlquery.Node{doc})
+	case PAGE_JS:
+		if pl.root.exp != nil && pl.root.json == nil {
+			// a bare regexp: selector reads the script as it is
+			val, err = pl.root.pipeText(ctx, body)
+			break
+		}
+		data, perr := js2json(string(body))
+		if perr != nil {
+			return nil, perr
+		}
+		val, err = pl.root.pipeJsonValue(ctx, data)
+	default:
+		return nil, &RuleError{Value: pagetype, Msg: "unknown page type"}
+	}
+	return pl.result(ctx, val, err)
+}
+
+func (pl *Pipeline) result(ctx *runContext, val interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, ctx.fail(pl.root, "", err)
+	}
+	return val, nil
+}
+
+// RunHtml runs the pipeline over an already parsed html document or node.
+func (pl *Pipeline) RunHtml(s *goquery.Selection) (interface{}, error) {
+	ctx := newRunContext(Options{})
+	ctx.pagetype = PAGE_HTML
+	val, err := pl.root.pipeSelection(ctx, s)
+	return pl.result(ctx, val, err)
+}
+
+// RunJson runs the pipeline over a json body.
+func (pl *Pipeline) RunJson(body []byte) (interface{}, error) {
+	return pl.RunBytes(body, PAGE_JSON)
+}
+
+// RunJsonValue runs the pipeline over json that is already decoded, such as
+// the result of json.Unmarshal into an interface{}.
+func (pl *Pipeline) RunJsonValue(data interface{}) (interface{}, error) {
+	ctx := newRunContext(Options{})
+	ctx.pagetype = PAGE_JSON
+	val, err := pl.root.pipeJsonValue(ctx, data)
+	return pl.result(ctx, val, err)
+}
+
+// RunJs runs the pipeline over the literals found in a javascript body, see
+// js2json for how they are named.
+func (pl *Pipeline) RunJs(body []byte) (interface{}, error) {
+	return pl.RunBytes(body, PAGE_JS)
+}
+
+// RunText runs the pipeline over a plain text body.
+func (pl *Pipeline) RunText(body []byte) (interface{}, error) {
+	return pl.RunBytes(body, PAGE_TEXT)
+}
+
+// RunXml runs the pipeline over an already parsed xml document or node.
+func (pl *Pipeline) RunXml(doc *xmlquery.Node) (interface{}, error) {
+	ctx := newRunContext(Options{})
+	ctx.pagetype = PAGE_XML
+	val, err := pl.root.pipeXml(ctx, []*xmlquery.Node{doc})
+	return pl.result(ctx, val, err)
+}
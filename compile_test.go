@@ -0,0 +1,258 @@
+package gopiper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/bitly/go-simplejson"
+)
+
+const testHtml = `<html><head><title> Movie (2017) </title></head><body>
+<div id="info">
+	<span class="attrs"><a href="/d/1" rel="director">Director</a></span>
+	<ul class="list">
+		<li><a href="/m/1">one</a><em>1.5</em></li>
+		<li><a href="/m/2">two</a><em>2.5</em></li>
+		<li><a href="/m/3">three</a><em>3.5</em></li>
+	</ul>
+	<span class="pl">Episodes:</span> 12<br/>
+</div>
+</body></html>`
+
+var testHtmlRule = []byte(`
+{
+	"type": "map",
+	"subitem": [
+		{"name": "title", "type": "string", "selector": "title", "filter": "trimspace"},
+		{"name": "director", "type": "string", "selector": "#info .attrs a//attr[href]"},
+		{"name": "episode", "type": "int", "selector": "regexp:Episodes:</span> (\\d+)"},
+		{"name": "second", "type": "text", "selector": ".list li|eq(1)|children|first"},
+		{"name": "items", "type": "array", "selector": ".list li", "subitem": [
+			{"type": "map", "subitem": [
+				{"name": "name", "type": "text", "selector": "a"},
+				{"name": "url", "type": "href", "selector": "a"},
+				{"name": "score", "type": "float", "selector": "em"}
+			]}
+		]}
+	]
+}`)
+
+func TestCompileRun(t *testing.T) {
+	pipe := PipeItem{}
+	if err := json.Unmarshal(testHtmlRule, &pipe); err != nil {
+		t.Fatal(err)
+	}
+
+	pl, err := Compile(pipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"title":    "Movie (2017)",
+		"director": "/d/1",
+		"episode":  int64(12),
+		"second":   "two",
+		"items": []interface{}{
+			map[string]interface{}{"name": "one", "url": "/m/1", "score": 1.5},
+			map[string]interface{}{"name": "two", "url": "/m/2", "score": 2.5},
+			map[string]interface{}{"name": "three", "url": "/m/3", "score": 3.5},
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				val, err := pl.RunBytes([]byte(testHtml), PAGE_HTML)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !reflect.DeepEqual(val, expect) {
+					t.Errorf("unexpected result: %#v", val)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCompileError(t *testing.T) {
+	cases := []struct {
+		item PipeItem
+		path string
+	}{
+		{PipeItem{Type: PT_STRING, Selector: "regexp:(a"}, "$.selector"},
+		{PipeItem{Type: PT_STRING, Filter: "trimspace|nosuchfilter"}, "$.filter"},
+		{PipeItem{Type: PT_MAP, SubItem: []PipeItem{{Name: "a", Type: PT_ARRAY}}}, "$.subitem[0].subitem"},
+		{PipeItem{Selector: "div"}, "$.type"},
+	}
+
+	for _, c := range cases {
+		_, err := Compile(c.item)
+		rerr, ok := err.(*RuleError)
+		if !ok || rerr.Path != c.path {
+			t.Errorf("expect RuleError at %s, got %v", c.path, err)
+		}
+	}
+
+	// an empty type without a selector is still a constant
+	if val, err := MustCompile(PipeItem{Filter: "sprintf(%sx)"}).RunBytes([]byte(`<a></a>`), PAGE_HTML); err != nil || val != "x" {
+		t.Errorf("unexpected empty type result: %v %v", val, err)
+	}
+
+	// the css selector is broken but the same rule is fine for a json page
+	pl, err := Compile(PipeItem{Type: PT_STRING, Selector: "a[0]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pl.RunBytes([]byte(`<a></a>`), PAGE_HTML); err == nil {
+		t.Error("expect css selector error")
+	}
+	if val, err := pl.RunBytes([]byte(`{"a": ["x"]}`), PAGE_JSON); err != nil || val != "x" {
+		t.Errorf("unexpected json result: %v %v", val, err)
+	}
+
+	// CompileFor checks the dialect of the page type
+	li := PipeItem{Type: PT_MAP, SubItem: []PipeItem{{Name: "a", Type: PT_STRING, Selector: "li|foo"}}}
+	if _, err := Compile(li); err != nil {
+		t.Errorf("li|foo is a valid xpath union, got %v", err)
+	}
+	_, err = CompileFor(li, PAGE_HTML)
+	if rerr, ok := err.(*RuleError); !ok || rerr.Path != "$.subitem[0].selector" || !strings.Contains(rerr.Msg, "unknown selector function") {
+		t.Errorf("expect unknown function RuleError, got %v", err)
+	}
+	if _, err := CompileFor(PipeItem{Type: PT_STRING, Selector: "a[0]"}, PAGE_JSON); err != nil {
+		t.Errorf("unexpected json dialect error: %v", err)
+	}
+	if _, err := CompileFor(PipeItem{Type: PT_STRING, Selector: "a[0]"}, PAGE_HTML); err == nil {
+		t.Error("expect css selector error")
+	}
+	if _, err := CompileFor(PipeItem{Type: PT_STRING, Selector: "a"}, "pdf"); err == nil {
+		t.Error("expect unknown page type error")
+	}
+	jp := PipeItem{Type: PT_JSON_PARSE, Selector: "script", SubItem: []PipeItem{{Type: PT_STRING, Selector: "a[0]"}}}
+	if _, err := CompileFor(jp, PAGE_HTML); err != nil {
+		t.Errorf("jsonparse sub rules read json: %v", err)
+	}
+}
+
+func BenchmarkPipeBytes(b *testing.B) {
+	pipe := PipeItem{}
+	json.Unmarshal(testHtmlRule, &pipe)
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewReader([]byte(testHtml)))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pl, _ := Compile(pipe)
+		pl.RunHtml(doc.Selection)
+	}
+}
+
+func BenchmarkPipelineRun(b *testing.B) {
+	pipe := PipeItem{}
+	json.Unmarshal(testHtmlRule, &pipe)
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewReader([]byte(testHtml)))
+	pl := MustCompile(pipe)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pl.RunHtml(doc.Selection)
+	}
+}
+
+var testJsonArrayRule = []byte(`{
+	"type": "array",
+	"selector": "items",
+	"subitem": [{
+		"type": "map",
+		"subitem": [
+			{"name": "id", "type": "int", "selector": "id"},
+			{"name": "title", "type": "string", "selector": "title"}
+		]
+	}]
+}`)
+
+func testJsonArray(size int) []byte {
+	buf := bytes.NewBufferString(`{"items": [`)
+	for i := 0; i < size; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(buf, `{"id": %d, "title": "item %d", "tags": ["a", "b"], "price": {"value": 1.5}}`, i, i)
+	}
+	buf.WriteString(`]}`)
+	return buf.Bytes()
+}
+
+// BenchmarkJsonArrayRoundTrip is what the json engine used to do for the
+// rule above: every array element and map was encoded again and parsed by
+// each sub rule.
+func BenchmarkJsonArrayRoundTrip(b *testing.B) {
+	body := testJsonArray(50000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		js, _ := simplejson.NewJson(body)
+		res := make([]interface{}, 0)
+		for _, r := range js.Get("items").MustArray() {
+			data, _ := json.Marshal(r)
+			item, _ := simplejson.NewJson(data)
+			data, _ = json.Marshal(item)
+			id, _ := simplejson.NewJson(data)
+			title, _ := simplejson.NewJson(data)
+			res = append(res, map[string]interface{}{
+				"id":    id.Get("id").MustInt64(0),
+				"title": title.Get("title").MustString(""),
+			})
+		}
+	}
+}
+
+func BenchmarkJsonArray(b *testing.B) {
+	pipe := PipeItem{}
+	json.Unmarshal(testJsonArrayRule, &pipe)
+	pl := MustCompile(pipe)
+	body := testJsonArray(50000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pl.RunJson(body)
+	}
+}
+
+func TestJsonArray(t *testing.T) {
+	pipe := PipeItem{}
+	json.Unmarshal(testJsonArrayRule, &pipe)
+	pl := MustCompile(pipe)
+
+	val, err := pl.RunJson(testJsonArray(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data interface{}
+	json.Unmarshal(testJsonArray(3), &data)
+	decoded, err := pl.RunJsonValue(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []interface{}{
+		map[string]interface{}{"id": int64(0), "title": "item 0"},
+		map[string]interface{}{"id": int64(1), "title": "item 1"},
+		map[string]interface{}{"id": int64(2), "title": "item 2"},
+	}
+	if !reflect.DeepEqual(val, expect) || !reflect.DeepEqual(decoded, expect) {
+		t.Fatalf("unexpected json array result: %#v %#v", val, decoded)
+	}
+}
@@ -0,0 +1,395 @@
+package gopiper
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// date_layouts are tried in order by dateparse without a layout argument.
+// Layouts without a year take the year of now.
+var date_layouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	time.RFC1123,
+	time.RFC1123Z,
+	time.RFC850,
+	time.RFC822,
+	time.RFC822Z,
+	time.ANSIC,
+	time.UnixDate,
+	"2006-1-2 15:04:05",
+	"2006-1-2 15:04",
+	"2006-1-2T15:04:05",
+	"2006-1-2",
+	"2006/1/2 15:04:05",
+	"2006/1/2 15:04",
+	"2006/1/2",
+	"2006.1.2 15:04:05",
+	"2006.1.2",
+	"2006年1月2日 15:04:05",
+	"2006年1月2日 15:04",
+	"2006年1月2日15:04",
+	"2006年1月2日",
+	"2006年1月",
+	"Jan 2, 2006 15:04",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	"2 Jan 2006",
+	"2 January 2006",
+	"1月2日 15:04",
+	"1月2日",
+	"1-2 15:04",
+}
+
+// date_layout_names are the names dateparse and dateformat accept for the
+// layouts of the time package.
+var date_layout_names = map[string]string{
+	"rfc3339":  time.RFC3339,
+	"rfc1123":  time.RFC1123,
+	"rfc1123z": time.RFC1123Z,
+	"rfc822":   time.RFC822,
+	"rfc822z":  time.RFC822Z,
+	"rfc850":   time.RFC850,
+	"ansic":    time.ANSIC,
+	"unixdate": time.UnixDate,
+	"datetime": "2006-01-02 15:04:05",
+	"date":     "2006-01-02",
+	"time":     "15:04:05",
+}
+
+func dateLayout(layout string) string {
+	if l, ok := date_layout_names[strings.ToLower(layout)]; ok {
+		return l
+	}
+	return layout
+}
+
+var (
+	date_offset_exp = regexp.MustCompile(`^(?:UTC|GMT)?([+-])(\d{1,2}):?(\d{2})?$`)
+
+	date_location_mu    sync.Mutex
+	date_location_cache = make(map[string]*time.Location)
+)
+
+// parseLocation reads a time zone name such as Asia/Shanghai, UTC or Local,
+// or a fixed offset such as +08:00.
+func parseLocation(name string) (*time.Location, bool) {
+	if m := date_offset_exp.FindStringSubmatch(name); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		offset := hours*3600 + minutes*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(name, offset), true
+	}
+	if name == "" || strings.ContainsAny(name, " ,:") {
+		return nil, false
+	}
+
+	date_location_mu.Lock()
+	defer date_location_mu.Unlock()
+	if loc, ok := date_location_cache[name]; ok {
+		return loc, loc != nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = nil
+	}
+	date_location_cache[name] = loc
+	return loc, loc != nil
+}
+
+// relative dates: `3 hours ago`, `an hour ago`, `3小时前`, `半小时前`,
+// `昨天 12:30`.
+var (
+	date_relative_en_exp = regexp.MustCompile(`^(\d+|an?|one)\s*(s|secs?|seconds?|m|mins?|minutes?|h|hrs?|hours?|d|days?|w|wks?|weeks?|mos?|months?|y|yrs?|years?)\s+ago$`)
+	date_relative_cn_exp = regexp.MustCompile(`^(\d+|[一二两三四五六七八九十]+|半)\s*(秒钟?|分钟?|小时|个?钟头|天|日|周|个?星期|个?礼拜|个?月|年)(?:之)?前$`)
+	date_day_exp         = regexp.MustCompile(`^(今天|昨天|前天|明天|后天|today|yesterday|tomorrow)\s*(\d{1,2})?[:：]?(\d{2})?(?:[:：](\d{2}))?$`)
+)
+
+var date_cn_digits = map[rune]int{'一': 1, '二': 2, '两': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+
+// parseCnCount reads the small counts of relative dates: 3, 三, 十二, 半.
+func parseCnCount(s string) (int, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, true
+	}
+	if s == "半" {
+		return 0, true
+	}
+	n, cur := 0, 0
+	for _, r := range s {
+		if r == '十' {
+			if cur == 0 {
+				cur = 1
+			}
+			n, cur = n+cur*10, 0
+			continue
+		}
+		d, ok := date_cn_digits[r]
+		if !ok {
+			return 0, false
+		}
+		cur = d
+	}
+	return n + cur, true
+}
+
+// parseRelativeDate reads `just now`, `3 hours ago`, `yesterday 12:30`,
+// `刚刚`, `3小时前` and `昨天 12:30` relative to now.
+func parseRelativeDate(s string, now time.Time) (time.Time, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "now", "just now", "刚刚", "刚才":
+		return now, true
+	}
+
+	if m := date_relative_en_exp.FindStringSubmatch(s); m != nil {
+		n := 1
+		if m[1] != "a" && m[1] != "an" && m[1] != "one" {
+			n, _ = strconv.Atoi(m[1])
+		}
+		unit := m[2]
+		switch {
+		case strings.HasPrefix(unit, "mo"):
+			return now.AddDate(0, -n, 0), true
+		case unit == "m" || strings.HasPrefix(unit, "mi"):
+			return now.Add(-time.Duration(n) * time.Minute), true
+		case strings.HasPrefix(unit, "s"):
+			return now.Add(-time.Duration(n) * time.Second), true
+		case strings.HasPrefix(unit, "h"):
+			return now.Add(-time.Duration(n) * time.Hour), true
+		case strings.HasPrefix(unit, "d"):
+			return now.AddDate(0, 0, -n), true
+		case strings.HasPrefix(unit, "w"):
+			return now.AddDate(0, 0, -7*n), true
+		case strings.HasPrefix(unit, "y"):
+			return now.AddDate(-n, 0, 0), true
+		}
+	}
+
+	if m := date_relative_cn_exp.FindStringSubmatch(s); m != nil {
+		n, ok := parseCnCount(m[1])
+		if !ok {
+			return time.Time{}, false
+		}
+		half := m[1] == "半"
+		unit := m[2]
+		switch {
+		case strings.HasPrefix(unit, "秒"):
+			return now.Add(-time.Duration(n) * time.Second), true
+		case strings.HasPrefix(unit, "分"):
+			return now.Add(-time.Duration(n) * time.Minute), true
+		case unit == "小时" || strings.HasSuffix(unit, "钟头"):
+			if half {
+				return now.Add(-30 * time.Minute), true
+			}
+			return now.Add(-time.Duration(n) * time.Hour), true
+		case unit == "天" || unit == "日":
+			return now.AddDate(0, 0, -n), true
+		case unit == "周" || strings.HasSuffix(unit, "星期") || strings.HasSuffix(unit, "礼拜"):
+			return now.AddDate(0, 0, -7*n), true
+		case strings.HasSuffix(unit, "月"):
+			if half {
+				return now.AddDate(0, 0, -15), true
+			}
+			return now.AddDate(0, -n, 0), true
+		case unit == "年":
+			if half {
+				return now.AddDate(0, -6, 0), true
+			}
+			return now.AddDate(-n, 0, 0), true
+		}
+	}
+
+	if m := date_day_exp.FindStringSubmatch(s); m != nil {
+		days := map[string]int{"今天": 0, "today": 0, "昨天": -1, "yesterday": -1, "前天": -2, "明天": 1, "tomorrow": 1, "后天": 2}[m[1]]
+		hour, _ := strconv.Atoi(m[2])
+		minute, _ := strconv.Atoi(m[3])
+		second, _ := strconv.Atoi(m[4])
+		y, mo, d := now.AddDate(0, 0, days).Date()
+		return time.Date(y, mo, d, hour, minute, second, 0, now.Location()), true
+	}
+	return time.Time{}, false
+}
+
+// parseDate reads s with the first matching layout, as a unix timestamp of 10
+// or 13 digits, or as a relative date. Times without a zone are in loc, dates
+// without a year in the last year that puts them before now.
+func parseDate(s string, layouts []string, loc *time.Location, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, ok := parseRelativeDate(s, now.In(loc)); ok {
+		return t, nil
+	}
+	if len(s) == 10 || len(s) == 13 {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return unixDate(n, loc), nil
+		}
+	}
+
+	for _, layout := range layouts {
+		t, err := time.ParseInLocation(layout, s, loc)
+		if err != nil {
+			continue
+		}
+		if !strings.Contains(layout, "2006") && !strings.Contains(layout, "06") {
+			return yearlessDate(t, loc, now)
+		}
+		return t, nil
+	}
+	return time.Time{}, errors.New("dateparse: cannot parse date '" + s + "'")
+}
+
+// yearlessDate puts a date parsed without a year, such as 12月3日, in the
+// last year where it is not after now; 2月29日 goes to the last leap year.
+func yearlessDate(t time.Time, loc *time.Location, now time.Time) (time.Time, error) {
+	year := now.In(loc).Year()
+	for y := year; y > year-8; y-- {
+		d := time.Date(y, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+		if d.Day() == t.Day() && !d.After(now) {
+			return d, nil
+		}
+	}
+	return time.Time{}, errors.New("dateparse: no year for " + t.Format("01-02"))
+}
+
+func unixDate(n int64, loc *time.Location) time.Time {
+	if n > 1e12 {
+		return time.Unix(n/1000, n%1000*int64(time.Millisecond)).In(loc)
+	}
+	return time.Unix(n, 0).In(loc)
+}
+
+// toDate converts a filter input to a time: a time.Time, a unix timestamp in
+// seconds or milliseconds, as a number or numeric string, or a date string in
+// one of the default layouts.
+func toDate(v interface{}, loc *time.Location, now time.Time) (time.Time, error) {
+	switch vt := v.(type) {
+	case time.Time:
+		return vt, nil
+	case int64:
+		return unixDate(vt, loc), nil
+	case int:
+		return unixDate(int64(vt), loc), nil
+	case float64:
+		return unixDate(int64(vt), loc), nil
+	case json.Number:
+		// a timestamp of a json or js page
+		f, err := vt.Float64()
+		if err != nil {
+			return time.Time{}, errors.New("not a date: " + vt.String())
+		}
+		return unixDate(int64(f), loc), nil
+	case string:
+		// 1512261000 or 1512261000.5, but not a year such as 2017
+		if f, err := strconv.ParseFloat(strings.TrimSpace(vt), 64); err == nil && f >= 1e8 {
+			return unixDate(int64(f), loc), nil
+		}
+		return parseDate(vt, date_layouts, loc, now)
+	}
+	return time.Time{}, errors.New("not a date: " + reflect.TypeOf(v).String())
+}
+
+// mapDates runs fn over a value or over each element of a list.
+func mapDates(src *reflect.Value, fn func(v interface{}) (interface{}, error)) (interface{}, error) {
+	switch src.Kind() {
+	case reflect.Slice, reflect.Array:
+		res := make([]interface{}, src.Len())
+		for i := range res {
+			v, err := fn(src.Index(i).Interface())
+			if err != nil {
+				return src.Interface(), err
+			}
+			res[i] = v
+		}
+		return res, nil
+	}
+	return fn(src.Interface())
+}
+
+func filterNow(ctx *FilterContext) time.Time {
+	if ctx.Now.IsZero() {
+		return time.Now()
+	}
+	return ctx.Now
+}
+
+// dateparse(layouts..., tz) reads a date into a time.Time. Without layouts
+// the date_layouts are tried; the last argument is a time zone when it names
+// one, e.g. dateparse(2006年1月2日, Asia/Shanghai) or dateparse(rfc1123).
+func dateparse(ctx *FilterContext, src *reflect.Value, args []FilterArg) (interface{}, error) {
+	now := filterNow(ctx)
+	loc := now.Location()
+	layouts := make([]string, 0, len(args))
+	for i, arg := range args {
+		if i == len(args)-1 {
+			if l, ok := parseLocation(arg.String()); ok {
+				loc = l
+				continue
+			}
+		}
+		layouts = append(layouts, dateLayout(arg.String()))
+	}
+	if len(layouts) == 0 {
+		layouts = date_layouts
+	}
+
+	return mapDates(src, func(v interface{}) (interface{}, error) {
+		if s, ok := v.(string); ok {
+			return parseDate(s, layouts, loc, now)
+		}
+		return toDate(v, loc, now)
+	})
+}
+
+// dateformat(layout, tz) formats a date, by default as 2006-01-02 15:04:05.
+func dateformat(ctx *FilterContext, src *reflect.Value, args []FilterArg) (interface{}, error) {
+	now := filterNow(ctx)
+	layout := date_layout_names["datetime"]
+	var loc *time.Location
+	if len(args) > 0 {
+		layout = dateLayout(args[0].String())
+	}
+	if len(args) > 1 {
+		l, ok := parseLocation(args[1].String())
+		if !ok {
+			return src.Interface(), errors.New("unknown time zone: " + args[1].String())
+		}
+		loc = l
+	}
+
+	return mapDates(src, func(v interface{}) (interface{}, error) {
+		t, err := toDate(v, now.Location(), now)
+		if err != nil {
+			return nil, err
+		}
+		if loc != nil {
+			t = t.In(loc)
+		}
+		return t.Format(layout), nil
+	})
+}
+
+// totimestamp converts a date to unix seconds, or milliseconds with
+// totimestamp(ms).
+func totimestamp(ctx *FilterContext, src *reflect.Value, args []FilterArg) (interface{}, error) {
+	now := filterNow(ctx)
+	ms := len(args) > 0 && args[0].String() == "ms"
+	return mapDates(src, func(v interface{}) (interface{}, error) {
+		t, err := toDate(v, now.Location(), now)
+		if err != nil {
+			return nil, err
+		}
+		if ms {
+			return t.UnixNano() / int64(time.Millisecond), nil
+		}
+		return t.Unix(), nil
+	})
+}
@@ -0,0 +1,129 @@
+package gopiper
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDateParse(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	now := time.Date(2018, 3, 15, 10, 0, 0, 0, shanghai)
+	ctx := &FilterContext{Now: now}
+
+	tests := []struct {
+		src    string
+		args   string
+		expect time.Time
+	}{
+		{"2017年12月3日", "", time.Date(2017, 12, 3, 0, 0, 0, 0, shanghai)},
+		{"2017年12月3日 08:30", "", time.Date(2017, 12, 3, 8, 30, 0, 0, shanghai)},
+		{"2017-12-03 08:30:05", "", time.Date(2017, 12, 3, 8, 30, 5, 0, shanghai)},
+		{"Sun, 03 Dec 2017 08:30:00 GMT", "", time.Date(2017, 12, 3, 16, 30, 0, 0, shanghai)},
+		{"03/12/2017", "02/01/2006", time.Date(2017, 12, 3, 0, 0, 0, 0, shanghai)},
+		{"03/12/2017", "'01/02/2006', '02/01/2006'", time.Date(2017, 3, 12, 0, 0, 0, 0, shanghai)},
+		{"2017-12-03 08:30", "'2006-01-02 15:04', UTC", time.Date(2017, 12, 3, 16, 30, 0, 0, shanghai)},
+		{"2017-12-03 08:30", "'2006-01-02 15:04', +09:00", time.Date(2017, 12, 3, 7, 30, 0, 0, shanghai)},
+		{"Sun, 03 Dec 2017 08:30:00 GMT", "rfc1123", time.Date(2017, 12, 3, 16, 30, 0, 0, shanghai)},
+		{"1512261000", "", time.Date(2017, 12, 3, 8, 30, 0, 0, shanghai)},
+		// without a year, the last one that is not after now
+		{"12月3日 08:30", "", time.Date(2017, 12, 3, 8, 30, 0, 0, shanghai)},
+		{"3月1日", "", time.Date(2018, 3, 1, 0, 0, 0, 0, shanghai)},
+		{"2月29日", "", time.Date(2016, 2, 29, 0, 0, 0, 0, shanghai)},
+		// relative dates
+		{"刚刚", "", now},
+		{"3 hours ago", "", now.Add(-3 * time.Hour)},
+		{"an hour ago", "", now.Add(-time.Hour)},
+		{"5 mins ago", "", now.Add(-5 * time.Minute)},
+		{"2 days ago", "", now.AddDate(0, 0, -2)},
+		{"1 month ago", "", now.AddDate(0, -1, 0)},
+		{"3小时前", "", now.Add(-3 * time.Hour)},
+		{"半小时前", "", now.Add(-30 * time.Minute)},
+		{"十二分钟前", "", now.Add(-12 * time.Minute)},
+		{"三天前", "", now.AddDate(0, 0, -3)},
+		{"2个月前", "", now.AddDate(0, -2, 0)},
+		{"昨天 12:30", "", time.Date(2018, 3, 14, 12, 30, 0, 0, shanghai)},
+		{"前天", "", time.Date(2018, 3, 13, 0, 0, 0, 0, shanghai)},
+		{"Yesterday 08:05", "", time.Date(2018, 3, 14, 8, 5, 0, 0, shanghai)},
+	}
+	for _, test := range tests {
+		args, err := parseFilterArgs(test.args, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := reflect.ValueOf(test.src)
+		val, err := dateparse(ctx, &src, args)
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		if tm, ok := val.(time.Time); !ok || !tm.Equal(test.expect) {
+			t.Fatalf("%s (%s): unexpected %v, expect %v", test.src, test.args, val, test.expect)
+		}
+	}
+
+	src := reflect.ValueOf("sometime")
+	if _, err := dateparse(ctx, &src, nil); err == nil {
+		t.Fatal("expect parse error")
+	}
+}
+
+func TestDateFilters(t *testing.T) {
+	now := time.Date(2018, 3, 15, 10, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	html := `<ul><li>2017年12月3日 08:30</li><li>3小时前</li><li>昨天 12:30</li></ul><p>Sun, 03 Dec 2017 08:30:00 GMT</p>`
+
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "dates", Type: PT_STRING_ARRAY, Selector: "li", Filter: "dateparse|dateformat"},
+		{Name: "day", Type: PT_STRING, Selector: "li|first", Filter: "dateparse|dateformat(date)"},
+		{Name: "utc", Type: PT_STRING, Selector: "li|first", Filter: "dateparse|dateformat(rfc3339, UTC)"},
+		{Name: "ts", Type: PT_STRING, Selector: "p", Filter: "dateparse(rfc1123)|totimestamp"},
+		{Name: "ms", Type: PT_STRING, Selector: "p", Filter: "totimestamp(ms)"},
+		{Name: "time", Type: PT_STRING, Selector: "p", Filter: "dateparse"},
+	}}
+	val, _, err := MustCompile(pipe).Extract([]byte(html), PAGE_HTML, Options{Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"dates": []interface{}{"2017-12-03 08:30:00", "2018-03-15 07:00:00", "2018-03-14 12:30:00"},
+		"day":   "2017-12-03",
+		"utc":   "2017-12-03T00:30:00Z",
+		"ts":    int64(1512289800),
+		"ms":    int64(1512289800000),
+	}
+	res := val.(map[string]interface{})
+	tm, ok := res["time"].(time.Time)
+	if !ok || !tm.Equal(time.Date(2017, 12, 3, 8, 30, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected time: %#v", res["time"])
+	}
+	delete(res, "time")
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf("unexpected date result: %#v", res)
+	}
+}
+
+func TestDateJsonTimestamp(t *testing.T) {
+	body := `{"s": 1512289800, "ms": 1512289800000, "str": "1512289800"}`
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "s", Type: PT_JSON_VALUE, Selector: "s", Filter: "dateformat(rfc3339, UTC)"},
+		{Name: "ms", Type: PT_JSON_VALUE, Selector: "ms", Filter: "totimestamp"},
+		{Name: "str", Type: PT_STRING, Selector: "str", Filter: "totimestamp(ms)"},
+	}}
+	val, res, err := MustCompile(pipe).Extract([]byte(body), PAGE_JSON, Options{})
+	if err != nil || len(res.Errors) != 0 {
+		t.Fatal(err, res)
+	}
+	expect := map[string]interface{}{
+		"s":   "2017-12-03T08:30:00Z",
+		"ms":  int64(1512289800),
+		"str": int64(1512289800000),
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected json timestamp result: %#v", val)
+	}
+
+	// a short number string is not read as a 1970 timestamp
+	if tm, err := toDate("2017", time.UTC, time.Now()); err == nil {
+		t.Fatalf("unexpected date: %v", tm)
+	}
+}
@@ -0,0 +1,88 @@
+package gopiper
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// RuleError reports a rule that can not be run, such as an unknown page type
+// or rule type. Path locates the bad value in the rule tree, for example
+// `$.subitem[3].type`.
+type RuleError struct {
+	Path  string
+	Value string
+	Msg   string
+}
+
+func (e *RuleError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %q", e.Msg, e.Value)
+	}
+	return fmt.Sprintf("%s: %s: %q", e.Path, e.Msg, e.Value)
+}
+
+// ErrNotFound is matched by errors.Is for every selector or attribute that
+// found nothing.
+var ErrNotFound = errors.New("not found")
+
+type notFoundError struct {
+	msg string
+}
+
+func (e *notFoundError) Error() string {
+	return e.msg
+}
+
+func (e *notFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+func notFound(msg string) error {
+	return &notFoundError{msg}
+}
+
+func subpath(path string, idx int) string {
+	return path + ".subitem[" + strconv.Itoa(idx) + "]"
+}
+
+func unknownTypeError(path, tp string) error {
+	return &RuleError{Path: path + ".type", Value: tp, Msg: "unknown pipe type"}
+}
+
+func missingTypeError(path, selector string) error {
+	return &RuleError{Path: path + ".type", Value: selector, Msg: "pipe type is missing for the selector"}
+}
+
+func unsupportTypeError(path, tp, pagetype string) error {
+	return &RuleError{Path: path + ".type", Value: tp, Msg: "pipe type not supported by " + pagetype + " page"}
+}
+
+var known_types = map[string]bool{
+	PT_INT: true, PT_FLOAT: true, PT_BOOL: true, PT_STRING: true,
+	PT_INT_ARRAY: true, PT_FLOAT_ARRAY: true, PT_BOOL_ARRAY: true, PT_STRING_ARRAY: true,
+	PT_MAP: true, PT_ARRAY: true, PT_JSON_VALUE: true, PT_JSON_PARSE: true, PT_CONST: true,
+	PT_TEXT: true, PT_HREF: true, PT_HTML: true, PT_IMG_SRC: true, PT_IMG_ALT: true,
+	PT_TEXT_ARRAY: true, PT_HREF_ARRAY: true, PT_OUT_HTML: true,
+}
+
+// absurl_types are the types whose values AbsUrl resolves, with attr[x] and
+// attr-array[x].
+var absurl_types = map[string]bool{
+	PT_HREF: true, PT_IMG_SRC: true, PT_HREF_ARRAY: true,
+	PT_STRING: true, PT_STRING_ARRAY: true, PT_TEXT: true, PT_TEXT_ARRAY: true,
+}
+
+var (
+	attr_type_exp       = regexp.MustCompile("^" + PT_ATTR + "$")
+	attr_array_type_exp = regexp.MustCompile("^" + PT_ATTR_ARRAY + "$")
+)
+
+// isConstType reports a rule whose value is its selector text run through the
+// filters. An empty type is kept as an alias for rules written before
+// PT_CONST existed, only without a selector: Compile rejects a selector with
+// no type, which is far more often a forgotten type than a constant.
+func isConstType(tp string) bool {
+	return tp == PT_CONST || tp == ""
+}
@@ -14,12 +14,12 @@ import (
 func init() {
 	RegisterFilter("preadd", preadd)
 	RegisterFilter("postadd", postadd)
-	RegisterFilter("replace", replace)
+	RegisterArgsFilter("replace", replace)
 	RegisterFilter("split", split)
 	RegisterFilter("join", join)
 	RegisterFilter("trim", trim)
 	RegisterFilter("trimspace", trimspace)
-	RegisterFilter("substr", substr)
+	RegisterArgsFilter("substr", substr)
 	RegisterFilter("intval", intval)
 	RegisterFilter("floatval", floatval)
 	RegisterFilter("hrefreplace", hrefreplace)
@@ -28,19 +28,143 @@ func init() {
 	RegisterFilter("unescape", unescape)
 	RegisterFilter("escape", escape)
 	RegisterFilter("sprintf", sprintf)
-	RegisterFilter("sprintfmap", sprintfmap)
+	RegisterArgsFilter("sprintfmap", sprintfmap)
 	RegisterFilter("unixtime", unixtime)
 	RegisterFilter("unixmill", unixmill)
-	RegisterFilter("paging", paging)
+	RegisterArgsFilter("paging", paging)
 	RegisterFilter("quote", quote)
 	RegisterFilter("unquote", unquote)
+	RegisterContextFilter("absurl", absurl)
+	RegisterContextFilter("dateparse", dateparse)
+	RegisterContextFilter("dateformat", dateformat)
+	RegisterContextFilter("totimestamp", totimestamp)
+	RegisterArgsFilter("number", number)
+	RegisterArgsFilter("price", price)
+	RegisterArgsFilter("cnnum", cnnum)
+	RegisterArgsFilter("regexpreplace", regexpreplace)
+	RegisterArgsFilter("regexpmatch", regexpmatch)
+	RegisterArgsFilter("regexpextract", regexpextract)
+	RegisterArgsFilter("regexpsplit", regexpsplit)
 }
 
 type FilterFunction func(src *reflect.Value, params *reflect.Value) (interface{}, error)
 
-var filters = make(map[string]FilterFunction)
+// ArgsFilterFunction is a filter that receives its parsed argument list, so
+// that `replace(',', "")` can replace a comma with nothing.
+type ArgsFilterFunction func(src *reflect.Value, args []FilterArg) (interface{}, error)
+
+// FilterArg is one comma separated argument of a filter call. A quoted
+// argument, '...' or "...", is a string with its escapes resolved; an unquoted
+// one is trimmed and read as an int64, a float64 or a bool when it looks like
+// one, and as a string otherwise; one that is only whitespace stays as
+// written. Raw is the argument as written.
+type FilterArg struct {
+	Raw    string
+	Value  interface{}
+	Quoted bool
+}
+
+// String returns a string argument, or an unquoted one as written.
+func (a FilterArg) String() string {
+	if s, ok := a.Value.(string); ok {
+		return s
+	}
+	return strings.TrimSpace(a.Raw)
+}
+
+// Pattern returns a regular expression argument. Unlike String the
+// backslashes of a quoted pattern are kept, only \' or \" is unescaped, so
+// '\d+' is written as in Go.
+func (a FilterArg) Pattern() string {
+	s := strings.TrimSpace(a.Raw)
+	if !a.Quoted {
+		return s
+	}
+	q := s[:1]
+	return strings.Replace(s[1:len(s)-1], "\\"+q, q, -1)
+}
+
+// Int returns an integer argument.
+func (a FilterArg) Int() (int, error) {
+	switch v := a.Value.(type) {
+	case int64:
+		return int(v), nil
+	case string:
+		return strconv.Atoi(strings.TrimSpace(v))
+	}
+	return 0, errors.New("argument " + a.Raw + " is not an integer")
+}
+
+// ContextFilterFunction is a filter that also receives the extraction it
+// runs in, see FilterContext.
+type ContextFilterFunction func(ctx *FilterContext, src *reflect.Value, args []FilterArg) (interface{}, error)
+
+// FilterContext describes the filter call and the extraction around it.
+type FilterContext struct {
+	BaseURL  string                 // Options.BaseURL
+	PageType string                 // html, json, js, xml or text
+	Path     string                 // rule path, e.g. $.subitem[3]
+	Rule     *PipeItem              // the rule of the filter, must not be modified
+	Parent   map[string]interface{} // fields of the enclosing map extracted so far, nil outside a map
+	Vars     map[string]interface{} // Options.Vars
+	Now      time.Time              // Options.Now, the time relative dates are read against
+
+	Filter string      // name of the filter
+	Params string      // raw parameters, as a FilterFunction gets them
+	Args   []FilterArg // parsed parameters, as an ArgsFilterFunction gets them
+}
+
+// filterfunc is a registered filter. Every kind of filter is adapted to a
+// ContextFilterFunction; args tells whether it takes parsed arguments.
+type filterfunc struct {
+	fn   ContextFilterFunction
+	args bool
+}
+
+var filters = make(map[string]*filterfunc)
+
+func legacyFilter(fn FilterFunction) *filterfunc {
+	return &filterfunc{fn: func(ctx *FilterContext, src *reflect.Value, args []FilterArg) (interface{}, error) {
+		params := reflect.ValueOf(ctx.Params)
+		return fn(src, &params)
+	}}
+}
+
+func argsFilter(fn ArgsFilterFunction) *filterfunc {
+	return &filterfunc{args: true, fn: func(ctx *FilterContext, src *reflect.Value, args []FilterArg) (interface{}, error) {
+		return fn(src, args)
+	}}
+}
 
 func RegisterFilter(name string, fn FilterFunction) {
+	registerFilter(name, legacyFilter(fn))
+}
+
+func ReplaceFilter(name string, fn FilterFunction) {
+	replaceFilter(name, legacyFilter(fn))
+}
+
+// RegisterArgsFilter registers a filter that takes an argument list rather
+// than the raw parameter string.
+func RegisterArgsFilter(name string, fn ArgsFilterFunction) {
+	registerFilter(name, argsFilter(fn))
+}
+
+func ReplaceArgsFilter(name string, fn ArgsFilterFunction) {
+	replaceFilter(name, argsFilter(fn))
+}
+
+// RegisterContextFilter registers a filter that takes an argument list and
+// the FilterContext of the call.
+func RegisterContextFilter(name string, fn ContextFilterFunction) {
+	registerFilter(name, &filterfunc{fn: fn, args: true})
+}
+
+func ReplaceContextFilter(name string, fn ContextFilterFunction) {
+	replaceFilter(name, &filterfunc{fn: fn, args: true})
+}
+
+func registerFilter(name string, fn *filterfunc) {
 	_, existing := filters[name]
 	if existing {
 		panic(fmt.Sprintf("Filter with name '%s' is already registered.", name))
@@ -48,7 +172,7 @@ func RegisterFilter(name string, fn FilterFunction) {
 	filters[name] = fn
 }
 
-func ReplaceFilter(name string, fn FilterFunction) {
+func replaceFilter(name string, fn *filterfunc) {
 	_, existing := filters[name]
 	if !existing {
 		panic(fmt.Sprintf("Filter with name '%s' does not exist (therefore cannot be overridden).", name))
@@ -56,70 +180,141 @@ func ReplaceFilter(name string, fn FilterFunction) {
 	filters[name] = fn
 }
 
-func applyFilter(name string, src *reflect.Value, params *reflect.Value) (interface{}, error) {
-	fn, existing := filters[name]
-	if !existing {
-		return nil, errors.New(fmt.Sprintf("Filter with name '%s' not found.", name))
+// filtercall is one parsed step of a filter chain such as `replace(a,b)`.
+// params is the raw parameter string of a FilterFunction, args the argument
+// list of the other kinds.
+type filtercall struct {
+	name   string
+	params string
+	args   []FilterArg
+	fn     *filterfunc
+}
+
+type filterchain []filtercall
+
+// parseFilter tokenizes a filter chain and looks the filters up; fn is nil
+// for a name that is not registered.
+func parseFilter(value string) (filterchain, error) {
+	chain := make(filterchain, 0)
+	if strings.TrimSpace(value) == "" {
+		return chain, nil
+	}
+	calls, err := parsePipeCalls(value, 0)
+	if err != nil {
+		return nil, err
 	}
-	return fn(src, params)
+	for _, call := range calls {
+		fc := filtercall{name: call.name, params: unquoteParams(call.params), fn: filters[call.name]}
+		if fc.fn != nil && fc.fn.args {
+			if fc.args, err = parseFilterArgs(call.params, call.col+len(call.name)); err != nil {
+				return nil, err
+			}
+		}
+		chain = append(chain, fc)
+	}
+	return chain, nil
 }
 
-func callFilter(src interface{}, value string) (interface{}, error) {
+func compileFilter(value string) (filterchain, error) {
+	chain, err := parseFilter(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, fc := range chain {
+		if fc.fn == nil {
+			return nil, errors.New(fmt.Sprintf("Filter with name '%s' not found.", fc.name))
+		}
+	}
+	return chain, nil
+}
 
-	if src == nil || len(value) == 0 {
+// apply runs src through the chain. A failing filter passes on the value it
+// returns with the error, or its input when that is nil, unless onerr turns
+// the failure into an error that stops the chain.
+// ctx is copied for every call; nil runs the filters without context.
+func (chain filterchain) apply(ctx *FilterContext, src interface{}, onerr func(name string, err error) error) (interface{}, error) {
+	if src == nil {
 		return src, nil
 	}
 
-	exp, _ := regexp.Compile(`([a-zA-Z0-9\-_]+)(?:\(([\w\W]*?)\))?(\||$)`)
-	vt := exp.FindAllStringSubmatch(value, -1)
-
-	for _, v := range vt {
-		if len(v) < 3 {
+	for _, fc := range chain {
+		if fc.fn == nil {
 			continue
 		}
-		name := v[1]
-		params := v[2]
+		fctx := FilterContext{}
+		if ctx != nil {
+			fctx = *ctx
+		}
+		fctx.Filter, fctx.Params, fctx.Args = fc.name, fc.params, fc.args
 
 		src_value := reflect.ValueOf(src)
-		param_value := reflect.ValueOf(params)
-		next, err := applyFilter(name, &src_value, &param_value)
+		next, err := fc.fn.fn(&fctx, &src_value, fc.args)
 		if err != nil {
+			if onerr != nil {
+				if err = onerr(fc.name, err); err != nil {
+					return nil, err
+				}
+			}
+			if next != nil {
+				src = next
+			}
 			continue
 		}
 		src = next
-
 	}
 
 	return src, nil
 }
 
+func callFilter(src interface{}, value string) (interface{}, error) {
+	if src == nil || len(value) == 0 {
+		return src, nil
+	}
+	chain, err := parseFilter(value)
+	if err != nil {
+		return nil, err
+	}
+	return chain.apply(nil, src, nil)
+}
+
 func preadd(src *reflect.Value, params *reflect.Value) (interface{}, error) {
 	return params.String() + src.String(), nil
 }
 func postadd(src *reflect.Value, params *reflect.Value) (interface{}, error) {
 	return src.String() + params.String(), nil
 }
-func substr(src *reflect.Value, params *reflect.Value) (interface{}, error) {
-	vt := strings.Split(params.String(), ",")
-	if len(vt) == 1 {
-		start, _ := strconv.Atoi(vt[0])
-		return src.String()[start:], nil
-	} else if len(vt) == 2 {
-		start, _ := strconv.Atoi(vt[0])
-		end, _ := strconv.Atoi(vt[1])
-		return src.String()[start:end], nil
+func substr(src *reflect.Value, args []FilterArg) (interface{}, error) {
+	str := src.String()
+	if len(args) == 0 || len(args) > 2 {
+		return src.Interface(), nil
 	}
-	return src.Interface(), nil
+	start, err := args[0].Int()
+	if err != nil {
+		return src.Interface(), err
+	}
+	end := len(str)
+	if len(args) == 2 {
+		if end, err = args[1].Int(); err != nil {
+			return src.Interface(), err
+		}
+	}
+	if start < 0 || start > end || end > len(str) {
+		return src.Interface(), errors.New("substr out of range: " + strconv.Itoa(start) + ", " + strconv.Itoa(end))
+	}
+	return str[start:end], nil
 }
-func replace(src *reflect.Value, params *reflect.Value) (interface{}, error) {
-	vt := strings.Split(params.String(), ",")
-	if len(vt) == 1 {
-		return strings.Replace(src.String(), vt[0], "", -1), nil
-	} else if len(vt) == 2 {
-		return strings.Replace(src.String(), vt[0], vt[1], -1), nil
-	} else if len(vt) == 3 {
-		n, _ := strconv.Atoi(vt[2])
-		return strings.Replace(src.String(), vt[0], vt[1], n), nil
+func replace(src *reflect.Value, args []FilterArg) (interface{}, error) {
+	if len(args) == 0 || args[0].String() == "" {
+		return src.Interface(), errors.New("filter replace needs a non-empty search string")
+	}
+	switch len(args) {
+	case 1:
+		return strings.Replace(src.String(), args[0].String(), "", -1), nil
+	case 2:
+		return strings.Replace(src.String(), args[0].String(), args[1].String(), -1), nil
+	case 3:
+		n, _ := args[2].Int()
+		return strings.Replace(src.String(), args[0].String(), args[1].String(), n), nil
 	}
 	return src.Interface(), nil
 }
@@ -188,27 +383,175 @@ func join(src *reflect.Value, params *reflect.Value) (interface{}, error) {
 }
 
 func intval(src *reflect.Value, params *reflect.Value) (interface{}, error) {
-	if src.Interface() == nil {
+	if src.Interface() == nil || strings.TrimSpace(src.String()) == "" {
 		return 0, nil
 	}
-	v, _ := strconv.Atoi(src.String())
-	return v, nil
+	v, err := parseInteger(src.String(), default_number_format)
+	if err != nil {
+		return 0, err
+	}
+	return int(v), nil
 }
 
 func floatval(src *reflect.Value, params *reflect.Value) (interface{}, error) {
-	if src.Interface() == nil {
+	if src.Interface() == nil || strings.TrimSpace(src.String()) == "" {
 		return 0.0, nil
 	}
-	v, _ := strconv.ParseFloat(src.String(), 64)
+	v, _, err := parseNumber(src.String(), default_number_format)
+	if err != nil {
+		return 0.0, err
+	}
 	return v, nil
 }
 
 func hrefreplace(src *reflect.Value, params *reflect.Value) (interface{}, error) {
-	href_filter_regexp, _ := regexp.Compile(`href(\s*)=(\s*)([\w\W]+?)"`)
+	href_filter_regexp, _ := compileRegexp(`href(\s*)=(\s*)([\w\W]+?)"`)
 	return href_filter_regexp.ReplaceAllString(src.String(), params.String()), nil
 }
 
-func regexpreplace(src *reflect.Value, params *reflect.Value) (interface{}, error) {
+// regexpArg compiles the pattern, the first argument of the regexp filters,
+// through the shared cache.
+func regexpArg(name string, args []FilterArg) (*regexp.Regexp, error) {
+	if len(args) == 0 {
+		return nil, errors.New("filter " + name + " needs a pattern")
+	}
+	return compileRegexp(args[0].Pattern())
+}
+
+// regexpreplace(pattern, repl) replaces every match, repl may refer to the
+// groups as $1 or ${name}. Without repl the matches are removed.
+func regexpreplace(src *reflect.Value, args []FilterArg) (interface{}, error) {
+	exp, err := regexpArg("regexpreplace", args)
+	if err != nil {
+		return src.Interface(), err
+	}
+	repl := ""
+	if len(args) > 1 {
+		repl = args[1].String()
+	}
+	switch vt := src.Interface().(type) {
+	case string:
+		return exp.ReplaceAllString(vt, repl), nil
+	case []string:
+		res := make([]string, len(vt))
+		for i, s := range vt {
+			res[i] = exp.ReplaceAllString(s, repl)
+		}
+		return res, nil
+	}
+	return src.Interface(), nil
+}
+
+// regexpmatch(pattern) tells whether the value matches, a []bool for a list.
+func regexpmatch(src *reflect.Value, args []FilterArg) (interface{}, error) {
+	exp, err := regexpArg("regexpmatch", args)
+	if err != nil {
+		return src.Interface(), err
+	}
+	switch vt := src.Interface().(type) {
+	case string:
+		return exp.MatchString(vt), nil
+	case []string:
+		res := make([]bool, len(vt))
+		for i, s := range vt {
+			res[i] = exp.MatchString(s)
+		}
+		return res, nil
+	}
+	return src.Interface(), nil
+}
+
+// regexpextract(pattern, group, all) extracts a group of the first match, or
+// of every match with all. Like the regexp selector the default group is the
+// first one, or the whole match without groups; it may also be given by name.
+// A list gives the matches of all its strings.
+func regexpextract(src *reflect.Value, args []FilterArg) (interface{}, error) {
+	exp, err := regexpArg("regexpextract", args)
+	if err != nil {
+		return src.Interface(), err
+	}
+	group := 0
+	if exp.NumSubexp() > 0 {
+		group = 1
+	}
+	if len(args) > 1 && strings.TrimSpace(args[1].Raw) != "" {
+		if group, err = args[1].Int(); err != nil {
+			if group = exp.SubexpIndex(args[1].String()); group < 0 {
+				return src.Interface(), errors.New("regexpextract: no group " + args[1].Raw + " in " + exp.String())
+			}
+		}
+		if group < 0 || group > exp.NumSubexp() {
+			return src.Interface(), errors.New("regexpextract: no group " + args[1].Raw + " in " + exp.String())
+		}
+	}
+	all := false
+	if len(args) > 2 {
+		all = args[2].Value == true || args[2].String() == "all"
+	}
+
+	extract := func(s string, res []string) []string {
+		n := 1
+		if all {
+			n = -1
+		}
+		for _, m := range exp.FindAllStringSubmatch(s, n) {
+			res = append(res, m[group])
+		}
+		return res
+	}
+	switch vt := src.Interface().(type) {
+	case string:
+		res := extract(vt, nil)
+		if all {
+			if res == nil {
+				res = []string{}
+			}
+			return res, nil
+		}
+		if len(res) == 0 {
+			return "", nil
+		}
+		return res[0], nil
+	case []string:
+		res := make([]string, 0)
+		for _, s := range vt {
+			res = extract(s, res)
+		}
+		return res, nil
+	}
+	return src.Interface(), nil
+}
+
+// regexpsplit(pattern, n) splits the value around the matches, n limits the
+// number of parts as in regexp.Split. A list gives the parts of all its
+// strings.
+func regexpsplit(src *reflect.Value, args []FilterArg) (interface{}, error) {
+	exp, err := regexpArg("regexpsplit", args)
+	if err != nil {
+		return src.Interface(), err
+	}
+	n := -1
+	if len(args) > 1 {
+		if n, err = args[1].Int(); err != nil {
+			return src.Interface(), err
+		}
+	}
+	split := func(s string, res []string) []string {
+		if strings.TrimSpace(s) == "" {
+			return res
+		}
+		return append(res, exp.Split(s, n)...)
+	}
+	switch vt := src.Interface().(type) {
+	case string:
+		return split(vt, []string{}), nil
+	case []string:
+		res := make([]string, 0)
+		for _, s := range vt {
+			res = split(s, res)
+		}
+		return res, nil
+	}
 	return src.Interface(), nil
 }
 
@@ -294,25 +637,21 @@ func sprintf(src *reflect.Value, params *reflect.Value) (interface{}, error) {
 
 	return fmt.Sprintf(params.String(), src.Interface()), nil
 }
-func sprintfmap(src *reflect.Value, params *reflect.Value) (interface{}, error) {
-	if params == nil {
-		return src.Interface(), errors.New("filter split nil params")
-	}
+func sprintfmap(src *reflect.Value, args []FilterArg) (interface{}, error) {
 	msrc, ok := src.Interface().(map[string]interface{})
 	if ok == false {
 		return src.Interface(), errors.New("value is not map[string]interface{}")
 	}
-	vt := strings.Split(params.String(), ",")
-	if len(vt) <= 1 {
+	if len(args) <= 1 {
 		return src.Interface(), errors.New("params length must > 1")
 	}
 	p_array := []interface{}{}
-	for _, x := range vt[1:] {
-		if vm, ok := msrc[x]; ok {
+	for _, x := range args[1:] {
+		if vm, ok := msrc[x.String()]; ok {
 			p_array = append(p_array, vm)
 		}
 	}
-	return fmt.Sprintf(vt[0], p_array...), nil
+	return fmt.Sprintf(args[0].String(), p_array...), nil
 }
 
 func unixtime(src *reflect.Value, params *reflect.Value) (interface{}, error) {
@@ -323,30 +662,31 @@ func unixmill(src *reflect.Value, params *reflect.Value) (interface{}, error) {
 	return time.Now().UnixNano() / int64(time.Millisecond), nil
 }
 
-func paging(src *reflect.Value, params *reflect.Value) (interface{}, error) {
+func paging(src *reflect.Value, args []FilterArg) (interface{}, error) {
 
-	if params == nil {
-		return src.Interface(), errors.New("filter paging nil params")
-	}
 	src_type := src.Type().Kind()
 	if src_type != reflect.Slice && src_type != reflect.Array && src_type != reflect.String {
 		return src.Interface(), errors.New("value is not slice ,array or string")
 	}
-	vt := strings.Split(params.String(), ",")
-	if len(vt) < 2 {
+	if len(args) < 2 {
 		return src.Interface(), errors.New("params length must > 1")
 	}
 
-	start, err := strconv.Atoi(vt[0])
-	end, err := strconv.Atoi(vt[1])
+	start, err := args[0].Int()
+	if err != nil {
+		return src.Interface(), errors.New("params type error:need int." + err.Error())
+	}
+	end, err := args[1].Int()
 	if err != nil {
 		return src.Interface(), errors.New("params type error:need int." + err.Error())
 	}
 
 	offset := -1
-	if len(vt) == 3 {
-		offset, err = strconv.Atoi(vt[2])
-		return src.Interface(), errors.New("params type error:need int." + err.Error())
+	if len(args) == 3 {
+		offset, err = args[2].Int()
+		if err != nil {
+			return src.Interface(), errors.New("params type error:need int." + err.Error())
+		}
 		if offset < 1 {
 			return src.Interface(), errors.New("offset must > 0")
 		}
@@ -0,0 +1,198 @@
+package gopiper
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilterArgs(t *testing.T) {
+	args, err := parseFilterArgs(`',', "", 3, -1.5, true, a b ,'it\'s'`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]interface{}, 0)
+	for _, arg := range args {
+		values = append(values, arg.Value)
+	}
+	expect := []interface{}{",", "", int64(3), -1.5, true, "a b", "it's"}
+	if !reflect.DeepEqual(values, expect) {
+		t.Fatalf("unexpected args: %#v", values)
+	}
+	if !args[0].Quoted || args[2].Quoted || args[2].String() != "3" || args[5].Raw != " a b " {
+		t.Fatalf("unexpected arg details: %#v", args)
+	}
+
+	if _, err := parseFilterArgs(`a, 'b'c`, 7); err == nil || !strings.HasPrefix(err.Error(), "column 11:") {
+		t.Fatalf("expect column error, got %v", err)
+	}
+}
+
+func TestFilterArgsChain(t *testing.T) {
+	tests := []struct {
+		src    interface{}
+		filter string
+		expect interface{}
+	}{
+		{"1,234,567", `replace(',', '')`, "1234567"},
+		{"1,234,567", `replace(",","")|intval`, 1234567},
+		{"a b a", `replace(a, x, 1)`, "x b a"},
+		{"http://a/b", `replace(http://a,https://c)`, "https://c/b"},
+		{"(2016)", `replace(()|replace())`, "2016"},
+		{"a b c", `replace( )`, "abc"},
+		{"a b c", `replace( ,-)`, "a-b-c"},
+		{"Don't stop", `replace(Don't,Do)`, "Do stop"},
+		{"it's", `replace(it's, "it is")`, "it is"},
+		{"hello", `substr(1,3)`, "el"},
+		{"hello", `substr(2)`, "llo"},
+		{"p{0}", `paging(1,2)`, []string{"p1", "p2"}},
+		{"p{0}-{1}", `paging(0,1,10)`, []string{"p0-10", "p10-20"}},
+		{map[string]interface{}{"a": "x", "b": 2}, `sprintfmap('%v,%v', a, b)`, "x,2"},
+		// legacy filters see the raw parameters, or a single quoted string
+		{"a|b", `split(|)`, []string{"a", "b"}},
+		{"a|b", `split('|')|join(", ")`, "a, b"},
+	}
+	for _, test := range tests {
+		val, err := callFilter(test.src, test.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", test.filter, err)
+		}
+		if !reflect.DeepEqual(val, test.expect) {
+			t.Fatalf("%s: unexpected %#v", test.filter, val)
+		}
+	}
+
+	for _, params := range []string{`'', x`, ``} {
+		args, _ := parseFilterArgs(params, 0)
+		src := reflect.ValueOf("abc")
+		if _, err := replace(&src, args); err == nil {
+			t.Fatalf("replace(%s): expect error for an empty search string", params)
+		}
+	}
+	if _, err := compileFilter(`replace('a'b)`); err == nil || !strings.HasPrefix(err.Error(), "column 9:") {
+		t.Fatalf("expect column error, got %v", err)
+	}
+}
+
+func TestRegisterArgsFilter(t *testing.T) {
+	RegisterArgsFilter("test_repeat", func(src *reflect.Value, args []FilterArg) (interface{}, error) {
+		n, err := args[0].Int()
+		if err != nil {
+			return nil, err
+		}
+		return strings.Repeat(src.String(), n), nil
+	})
+	pipe := PipeItem{Type: PT_STRING, Selector: "b", Filter: "test_repeat(3)"}
+	val, err := pipe.PipeBytes([]byte(`<b>ab</b>`), PAGE_HTML)
+	if err != nil || val != "ababab" {
+		t.Fatalf("unexpected args filter result: %#v %v", val, err)
+	}
+}
+
+func TestContextFilter(t *testing.T) {
+	var seen []FilterContext
+	RegisterContextFilter("test_ctx", func(ctx *FilterContext, src *reflect.Value, args []FilterArg) (interface{}, error) {
+		seen = append(seen, *ctx)
+		prefix := ""
+		if len(args) > 0 {
+			prefix = fmt.Sprint(ctx.Parent[args[0].String()]) + "-"
+		}
+		return prefix + src.String() + "@" + fmt.Sprint(ctx.Vars["site"]), nil
+	})
+
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "id", Type: PT_STRING, Selector: "#id"},
+		{Name: "title", Type: PT_STRING, Selector: "h1", Filter: "trimspace|test_ctx(id)"},
+		{Name: "tags", Type: PT_MAP, Selector: "ul", SubItem: []PipeItem{
+			{Name: "first", Type: PT_STRING, Selector: "li|first"},
+			{Name: "last", Type: PT_STRING, Selector: "li|last", Filter: "test_ctx(first)"},
+		}},
+	}}
+	body := []byte(`<b id="id">7</b><h1> Title </h1><ul><li>a</li><li>b</li></ul>`)
+	opt := Options{BaseURL: "http://example.com/", Vars: map[string]interface{}{"site": "ex"}}
+	val, _, err := MustCompile(pipe).Extract(body, PAGE_HTML, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"id":    "7",
+		"title": "7-Title@ex",
+		"tags":  map[string]interface{}{"first": "a", "last": "a-b@ex"},
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected context filter result: %#v", val)
+	}
+
+	ctx := seen[0]
+	if ctx.BaseURL != opt.BaseURL || ctx.PageType != PAGE_HTML || ctx.Path != "$.subitem[1]" ||
+		ctx.Rule.Name != "title" || ctx.Filter != "test_ctx" || ctx.Params != "id" {
+		t.Fatalf("unexpected filter context: %#v", ctx)
+	}
+	if seen[1].Path != "$.subitem[2].subitem[1]" || seen[1].Parent["first"] != "a" {
+		t.Fatalf("unexpected nested filter context: %#v", seen[1])
+	}
+
+	// callFilter runs the filters without context
+	if v, err := callFilter("x", "test_ctx|postadd(!)"); err != nil || v != "x@<nil>!" {
+		t.Fatalf("unexpected result without context: %#v %v", v, err)
+	}
+}
+
+func TestRegexpFilters(t *testing.T) {
+	tests := []struct {
+		src    interface{}
+		filter string
+		expect interface{}
+	}{
+		{"豆瓣(2017) 评分", `regexpreplace('\s*\(\d+\)\s*', ' ')`, "豆瓣 评分"},
+		{"2017-12-03", `regexpreplace('(\d+)-(\d+)-(\d+)', '$3/$2/$1')`, "03/12/2017"},
+		{"a1b22c", `regexpreplace('\d+')`, "abc"},
+		{"v1.2", `regexpreplace('(?P<major>\d+)\.(?P<minor>\d+)', '${minor}.${major}')`, "v2.1"},
+		{[]string{"a1", "b2"}, `regexpreplace('\d')`, []string{"a", "b"}},
+		{"ID: 12345", `regexpmatch('^ID: \d+$')`, true},
+		{"ID: x", `regexpmatch('^ID: \d+$')`, false},
+		{[]string{"a1", "b"}, `regexpmatch('\d')`, []bool{true, false}},
+		{"共 35 页", `regexpextract('(\d+) 页')`, "35"},
+		{"共 35 页", `regexpextract('\d+')`, "35"},
+		{"2017-12-03", `regexpextract('(\d+)-(\d+)', 2)`, "12"},
+		{"2017-12-03", `regexpextract('(?P<year>\d+)-(?P<month>\d+)', month)`, "12"},
+		{"2017-12-03", `regexpextract('(\d+)-(\d+)', 0)`, "2017-12"},
+		{"no digits", `regexpextract('\d+')`, ""},
+		{"$1.5, $20", `regexpextract('\$([\d.]+)', 1, all)`, []string{"1.5", "20"}},
+		{"none", `regexpextract('\d+', 0, all)`, []string{}},
+		{[]string{"a1b2", "c3"}, `regexpextract('\d')`, []string{"1", "3"}},
+		{[]string{"a1b2", "c3"}, `regexpextract('\d', 0, all)`, []string{"1", "2", "3"}},
+		{"a, b;c", `regexpsplit('[,;]\s*')`, []string{"a", "b", "c"}},
+		{"a, b;c", `regexpsplit('[,;]\s*', 2)`, []string{"a", "b;c"}},
+		{"  ", `regexpsplit(',')`, []string{}},
+		{[]string{"a|b", "c"}, `regexpsplit('\|')`, []string{"a", "b", "c"}},
+		{"it's 5", `regexpextract('it\'s (\d)')`, "5"},
+		{" 1 ,2 ", `regexpsplit(',')|trimspace|join(-)`, "1-2"},
+	}
+	for _, test := range tests {
+		val, err := callFilter(test.src, test.filter)
+		if err != nil {
+			t.Fatalf("%v %s: %v", test.src, test.filter, err)
+		}
+		if !reflect.DeepEqual(val, test.expect) {
+			t.Fatalf("%v %s: unexpected %#v", test.src, test.filter, val)
+		}
+	}
+
+	html := `<p>价格：$12.50 原价 $20</p>`
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "price", Type: PT_FLOAT, Selector: "p", Filter: `regexpextract('\$([\d.]+)')`},
+		{Name: "bad", Type: PT_STRING, Selector: "p", Filter: `regexpextract('(')`},
+		{Name: "group", Type: PT_STRING, Selector: "p", Filter: `regexpextract('(\d+)', 3)`},
+		{Name: "negative", Type: PT_STRING, Selector: "p", Filter: `regexpextract('(\d)', -1)`},
+	}}
+	val, report, err := MustCompile(pipe).Extract([]byte(html), PAGE_HTML, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := val.(map[string]interface{}); res["price"] != 12.5 || len(report.Errors) != 3 {
+		t.Fatalf("unexpected regexp filter result: %#v %v", val, report)
+	}
+}
@@ -0,0 +1,310 @@
+package gopiper
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const testDoubanHtml = `<html><body><div id="content">
+<div class="gtleft"><a class="bn-sharing" data-type="电影" data-pic="https://img.example.com/p1.jpg">share</a></div>
+<div id="related-pic"><div class="related-pic-bd">
+	<a class="related-pic-video" href="/v"><img src="/video.jpg"/></a>
+	<a href="/p/1"><img src="/albumicon/1.jpg" alt="one"/></a>
+	<a href="/p/2"><img src="/albumicon/2.jpg" alt="two"/></a>
+</div></div>
+<div id="info">
+	<span class="attrs"><a rel="v:directedBy" href="//movie.example.com/d">Director</a></span>
+	<p class="note">Own <b>bold</b> text</p>
+	<a class="mail" href="mailto:a@b.com" title="a@b">mail</a>
+</div>
+<form>
+	<input name="q" value="keyword"/>
+	<textarea name="t">area</textarea>
+	<select name="s"><option value="1">one</option><option value="2" selected>two</option></select>
+</form>
+</div></body></html>`
+
+func TestHtmlSuffix(t *testing.T) {
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		// README examples
+		{Name: "fenlei", Type: PT_STRING, Selector: "#content .gtleft a.bn-sharing@attr[data-type]"},
+		{Name: "thumbnail", Type: PT_STRING, Selector: "#content .gtleft a.bn-sharing@attr[data-pic]"},
+		{Name: "imgs", Type: PT_STRING_ARRAY, Selector: "#related-pic .related-pic-bd a:not(.related-pic-video) img@attr[src]"},
+		{Name: "direct", Type: PT_STRING_ARRAY, Selector: `#info span.attrs a[rel=v\:directedBy]`},
+		// both separators, every value
+		{Name: "old", Type: PT_STRING, Selector: "#content .gtleft a.bn-sharing//attr[data-type]"},
+		{Name: "alts", Type: PT_TEXT_ARRAY, Selector: "#related-pic img@attr[alt]"},
+		{Name: "text", Type: PT_STRING, Selector: "#info .note@text"},
+		{Name: "html", Type: PT_STRING, Selector: "#info .note@html"},
+		{Name: "outhtml", Type: PT_STRING, Selector: "#info .note b@outhtml"},
+		{Name: "owntext", Type: PT_STRING, Selector: "#info .note@ownText"},
+		{Name: "tags", Type: PT_STRING_ARRAY, Selector: "form *@tagName"},
+		{Name: "vals", Type: PT_STRING_ARRAY, Selector: "form input, form textarea, form select@val"},
+		{Name: "self", Type: PT_MAP, Selector: "#info .note", SubItem: []PipeItem{
+			{Name: "tag", Type: PT_STRING, Selector: "@tagname"},
+		}},
+		// quoted or bracketed separators are css
+		{Name: "href", Type: PT_STRING, Selector: "#info a[href^='//movie']@attr[href]"},
+		{Name: "mail", Type: PT_STRING, Selector: `#info a[title="a@b"]//attr[href]`},
+	}}
+
+	val, err := pipe.PipeBytes([]byte(testDoubanHtml), PAGE_HTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"fenlei":    "电影",
+		"thumbnail": "https://img.example.com/p1.jpg",
+		"imgs":      []string{"/albumicon/1.jpg", "/albumicon/2.jpg"},
+		"direct":    []string{"Director"},
+		"old":       "电影",
+		"alts":      []string{"one", "two"},
+		"text":      "Own bold text",
+		"html":      "Own <b>bold</b> text",
+		"outhtml":   "<b>bold</b>",
+		"owntext":   "Own  text",
+		"tags":      []string{"input", "textarea", "select", "option", "option"},
+		"vals":      []string{"keyword", "area", "2"},
+		"self":      map[string]interface{}{"tag": "p"},
+		"href":      "//movie.example.com/d",
+		"mail":      "mailto:a@b.com",
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected suffix result: %#v", val)
+	}
+}
+
+func TestHtmlSuffixSplit(t *testing.T) {
+	tests := []struct {
+		selector, node, suffix string
+		ok                     bool
+	}{
+		{"a@attr[href]", "a", "attr[href]", true},
+		{"a//text", "a", "text", true},
+		{"a|eq(1)@html", "a|eq(1)", "html", true},
+		{"@text", "", "text", true},
+		{"a[href*='@']", "a[href*='@']", "", false},
+		{`a[title="x\"@"]@val`, `a[title="x\"@"]`, "val", true},
+		{"p:contains(a//b)", "p:contains(a//b)", "", false},
+	}
+	for _, test := range tests {
+		node, suffix, ok := splitHtmlSuffix(test.selector)
+		if node != test.node || suffix != test.suffix || ok != test.ok {
+			t.Fatalf("%s: unexpected split %q %q %v", test.selector, node, suffix, ok)
+		}
+	}
+
+	if _, err := compileHtmlSelector("a@href"); err == nil {
+		t.Fatal("expect error for an unknown suffix")
+	}
+	// the attribute name is read once, when the selector is compiled
+	if hp, err := compileHtmlSelector("a@attr[data-src]"); err != nil || hp.attr != "attr[data-src]" || hp.attrName != "data-src" {
+		t.Fatalf("unexpected attr suffix: %+v %v", hp, err)
+	}
+	if hp, err := compileHtmlSelector("a@HTML"); err != nil || hp.attr != "html" || hp.attrName != "" {
+		t.Fatalf("unexpected html suffix: %+v %v", hp, err)
+	}
+}
+
+func TestHtmlXpath(t *testing.T) {
+	html := `<html><body><table>
+	<tr><td>Name</td><td>Phone</td></tr>
+	<tr><td>Price</td><td>12.5</td></tr>
+	<tr><td>Tags</td><td><a href="/t/1">a</a><a href="/t/2">b</a></td></tr>
+	</table><ul><li class="x">one</li><li>two</li></ul></body></html>`
+
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "price", Type: PT_FLOAT, Selector: `xpath://td[text()='Price']/following-sibling::td`},
+		{Name: "hrefs", Type: PT_STRING_ARRAY, Selector: `xpath://td[text()='Tags']/following-sibling::td/a/@href`},
+		{Name: "tags", Type: PT_ARRAY, Selector: `xpath://a`, SubItem: []PipeItem{
+			{Type: PT_MAP, SubItem: []PipeItem{
+				{Name: "text", Type: PT_STRING, Selector: "xpath:."},
+				{Name: "href", Type: PT_STRING, Selector: "@attr[href]"},
+			}},
+		}},
+		// css and xpath mixed, xpath relative to the node of its parent
+		{Name: "list", Type: PT_MAP, Selector: "ul", SubItem: []PipeItem{
+			{Name: "first", Type: PT_STRING, Selector: "xpath:li[1]"},
+			{Name: "cls", Type: PT_STRING, Selector: "xpath:li/@class"},
+		}},
+		{Name: "year", Type: PT_INT, Selector: `xpath://tr[2]/td[2]|regexp:(\d+)`},
+	}}
+	val, err := pipe.PipeBytes([]byte(html), PAGE_HTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"price": 12.5,
+		"hrefs": []string{"/t/1", "/t/2"},
+		"tags": []interface{}{
+			map[string]interface{}{"text": "a", "href": "/t/1"},
+			map[string]interface{}{"text": "b", "href": "/t/2"},
+		},
+		"list": map[string]interface{}{"first": "one", "cls": "x"},
+		"year": int64(12),
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected xpath result: %#v", val)
+	}
+
+	bad := PipeItem{Type: PT_STRING, Selector: "xpath://td["}
+	if _, err = bad.PipeBytes([]byte(html), PAGE_HTML); err == nil {
+		t.Fatal("expect xpath error")
+	}
+}
+
+func TestHtmlFuncs(t *testing.T) {
+	html := `<html><body><div class="box"><h2>Info</h2><ul>
+	<li>one</li><li class="hot"><b>two</b></li><li>three</li><li class="end">four</li><li>five</li>
+	</ul></div></body></html>`
+
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "has", Type: PT_STRING_ARRAY, Selector: "li|has(b)"},
+		{Name: "contains", Type: PT_STRING_ARRAY, Selector: "li|contains(re)"},
+		{Name: "slice", Type: PT_STRING_ARRAY, Selector: "li|slice(1,3)"},
+		{Name: "tail", Type: PT_STRING_ARRAY, Selector: "li|slice(-2)"},
+		{Name: "clamp", Type: PT_STRING_ARRAY, Selector: "li|slice(-9,9)"},
+		{Name: "empty", Type: PT_STRING_ARRAY, Selector: "li|slice(3,1)"},
+		{Name: "closest", Type: PT_STRING, Selector: "li.hot b|closest(div)@attr[class]"},
+		{Name: "nextuntil", Type: PT_STRING_ARRAY, Selector: "li.hot|nextuntil(.end)"},
+		{Name: "prevuntil", Type: PT_STRING_ARRAY, Selector: "li.end|prevuntil(.hot)"},
+		{Name: "prevall", Type: PT_STRING_ARRAY, Selector: "li.end|prevall"},
+		{Name: "last", Type: PT_STRING, Selector: "li|eq(-1)"},
+		{Name: "even", Type: PT_STRING_ARRAY, Selector: "li|even"},
+		{Name: "odd", Type: PT_STRING_ARRAY, Selector: "li|odd"},
+	}}
+	val, err := pipe.PipeBytes([]byte(html), PAGE_HTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"has":       []string{"two"},
+		"contains":  []string{"three"},
+		"slice":     []string{"two", "three"},
+		"tail":      []string{"four", "five"},
+		"clamp":     []string{"one", "two", "three", "four", "five"},
+		"empty":     nil,
+		"closest":   "box",
+		"nextuntil": []string{"three"},
+		"prevuntil": []string{"three"},
+		"prevall":   []string{"three", "two", "one"},
+		"last":      "five",
+		"even":      []string{"one", "three", "five"},
+		"odd":       []string{"two", "four"},
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected function result: %#v", val)
+	}
+
+	// a quote inside a word is a plain character
+	quoted := PipeItem{Type: PT_STRING_ARRAY, Selector: "li|contains(it's)"}
+	val, err = quoted.PipeBytes([]byte(`<ul><li>it's</li><li>its</li></ul>`), PAGE_HTML)
+	if err != nil || !reflect.DeepEqual(val, []string{"it's"}) {
+		t.Fatalf("unexpected contains(it's) result: %#v %v", val, err)
+	}
+
+	for _, selector := range []string{"li|nth(1)", "li|eq(x)", "li|slice(1,2,3)", "li|has"} {
+		if _, err := compileHtmlSelector(selector); err == nil {
+			t.Fatalf("%s: expect compile error", selector)
+		}
+	}
+}
+
+func TestHtmlRmIsolation(t *testing.T) {
+	html := `<div id="post"><p>Hello <script>track()</script><span class="ad">buy</span>world</p></div>`
+
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "clean", Type: PT_STRING, Selector: "#post p|rm(script, .ad)"},
+		{Name: "noscript", Type: PT_STRING, Selector: "#post p|rm(script)"},
+		{Name: "ad", Type: PT_STRING, Selector: "#post .ad"},
+		{Name: "html", Type: PT_STRING, Selector: "#post@html"},
+	}}
+	expect := map[string]interface{}{
+		"clean":    "Hello world",
+		"noscript": "Hello buyworld",
+		"ad":       "buy",
+		"html":     `<p>Hello <script>track()</script><span class="ad">buy</span>world</p>`,
+	}
+
+	// the same results whichever rule runs first
+	pl := MustCompile(pipe)
+	for i := 0; i < 2; i++ {
+		val, err := pl.RunBytes([]byte(html), PAGE_HTML)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(val, expect) {
+			t.Fatalf("unexpected rm result: %#v", val)
+		}
+		subs := pipe.SubItem
+		pipe.SubItem = []PipeItem{subs[3], subs[2], subs[1], subs[0]}
+		pl = MustCompile(pipe)
+	}
+
+	// the tree can still be walked after rm
+	html = `<div id="post"><p><span>Hello <b>bold</b></span><i>next</i></p><em>after</em></div>`
+	pipe = PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "parent", Type: PT_STRING, Selector: "span|rm(b)|parent"},
+		{Name: "next", Type: PT_STRING, Selector: "span|rm(b)|next"},
+		{Name: "closest", Type: PT_STRING, Selector: "span|rm(b)|closest(div)"},
+		{Name: "siblings", Type: PT_STRING_ARRAY, Selector: "p|rm(i)|siblings"},
+		{Name: "prevall", Type: PT_STRING, Selector: "i|rm(b)|prevall"},
+		{Name: "doc", Type: PT_STRING, Selector: "#post@html"},
+	}}
+	val, err := MustCompile(pipe).RunBytes([]byte(html), PAGE_HTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = map[string]interface{}{
+		"parent":   "Hello next",
+		"next":     "next",
+		"closest":  "Hello nextafter",
+		"siblings": []string{"after"},
+		"prevall":  "Hello bold",
+		"doc":      `<p><span>Hello <b>bold</b></span><i>next</i></p><em>after</em>`,
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected traversal after rm: %#v", val)
+	}
+
+	// array items share one copy of the document per run
+	html = `<ul><li><span><b>x</b>1</span></li><li><span><b>y</b>2</span></li></ul>`
+	pipe = PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "items", Type: PT_ARRAY, Selector: "li", SubItem: []PipeItem{{Type: PT_MAP, SubItem: []PipeItem{
+			{Name: "num", Type: PT_STRING, Selector: "span|rm(b)"},
+			{Name: "span", Type: PT_STRING, Selector: "span|rm(i)"},
+		}}}},
+		{Name: "list", Type: PT_STRING, Selector: "ul|rm(li:last-child)"},
+		{Name: "all", Type: PT_STRING, Selector: "ul"},
+	}}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := newRunContext(Options{})
+	val, err = MustCompile(pipe).root.pipeSelection(ctx, doc.Selection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"num": "1", "span": "x1"},
+			map[string]interface{}{"num": "2", "span": "y2"},
+		},
+		"list": "x1",
+		"all":  "x1y2",
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected rm in array items: %#v", val)
+	}
+	// elements, the document and the four text nodes, each with its copy
+	if n := len(ctx.edits.copies); n != 2*(len(doc.Find("*").Nodes)+5) {
+		t.Fatalf("expect one copy of the document, got %d nodes", n)
+	}
+}
@@ -0,0 +1,474 @@
+package gopiper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// js_assign_exp finds the places where a page hands data to javascript:
+// `var a = {`, `window.a.b = [`, `a = JSON.parse(` or a jsonp `cb({`.
+var js_assign_exp = regexp.MustCompile(`([A-Za-z_$][\w$]*(?:\s*\.\s*[A-Za-z_$][\w$]*)*)\s*(=|\()\s*(JSON\s*\.\s*parse\s*\(\s*)?(["'{\[])`)
+
+// js2json collects the object and array literals of a javascript body into
+// one map. Assignments are keyed by the last part of the target name
+// (`window.__INITIAL_STATE__` becomes `__INITIAL_STATE__`) and callback
+// arguments by the callback name; the first callback argument is also
+// available as `callback` so jsonp bodies with random names can be selected.
+// A dotted target is also kept under its full name without `window.`, so
+// `window.a.data` and `window.b.data` are `$['a.data']` and `$['b.data']`;
+// its last part then keeps the first of them, while a plain `var data`
+// always takes it.
+func js2json(body string) (map[string]interface{}, error) {
+	res := make(map[string]interface{})
+	owners := make(map[string]string) // the full name behind each short key
+	callback := false
+
+	pos := 0
+	for pos < len(body) {
+		loc := js_assign_exp.FindStringSubmatchIndex(body[pos:])
+		if loc == nil {
+			break
+		}
+		for i := range loc {
+			if loc[i] >= 0 {
+				loc[i] += pos
+			}
+		}
+
+		parts := strings.Split(body[loc[2]:loc[3]], ".")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		if len(parts) > 1 && parts[0] == "window" {
+			parts = parts[1:]
+		}
+		name, full := parts[len(parts)-1], strings.Join(parts, ".")
+		op := body[loc[4]:loc[5]]
+		jsonparse := loc[6] >= 0
+		start := loc[8]
+
+		// a string literal is only data when it is the JSON.parse argument
+		if body[start] == '"' || body[start] == '\'' {
+			if !jsonparse {
+				pos = loc[1]
+				continue
+			}
+		}
+
+		parser := &jsparser{src: body, pos: start}
+		val, err := parser.parseValue()
+		if err != nil {
+			pos = loc[1]
+			continue
+		}
+		if jsonparse {
+			str, ok := val.(string)
+			if !ok {
+				pos = loc[1]
+				continue
+			}
+			val, err = (&jsparser{src: str}).parseDocument()
+			if err != nil {
+				pos = loc[1]
+				continue
+			}
+		}
+
+		if _, ok := val.(string); ok {
+			pos = loc[1]
+			continue
+		}
+
+		if full != name {
+			res[full] = val
+		}
+		if owner, has := owners[name]; !has || owner == full || full == name {
+			res[name] = val
+			owners[name] = full
+		}
+		if op == "(" && !callback {
+			callback = true
+			if _, has := res["callback"]; !has {
+				res["callback"] = val
+			}
+		}
+		pos = parser.pos
+	}
+
+	if len(res) == 0 {
+		return nil, errors.New("js: no object or array literal found")
+	}
+	return res, nil
+}
+
+// jsparser reads javascript literals with the relaxed syntax found in pages:
+// single quoted strings, unquoted keys, trailing commas, comments, hex
+// numbers, `undefined` and the minified `!0`/`!1` booleans.
+type jsparser struct {
+	src string
+	pos int
+}
+
+func (p *jsparser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("js: offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *jsparser) parseDocument() (interface{}, error) {
+	val, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q after value", p.src[p.pos])
+	}
+	return val, nil
+}
+
+func (p *jsparser) skipSpace() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			end := strings.IndexAny(p.src[p.pos:], "\r\n")
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end + 4
+			}
+		default:
+			if r, size := utf8.DecodeRuneInString(p.src[p.pos:]); r == '\u00a0' || r == '\ufeff' || r == '\u2028' || r == '\u2029' {
+				p.pos += size
+				continue
+			}
+			return
+		}
+	}
+}
+
+func (p *jsparser) parseValue() (interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("unexpected end of input")
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case c == '{':
+		return p.parseObject()
+	case c == '[':
+		return p.parseArray()
+	case c == '"' || c == '\'' || c == '`':
+		return p.parseString()
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case c == '!':
+		if strings.HasPrefix(p.src[p.pos:], "!0") {
+			p.pos += 2
+			return true, nil
+		} else if strings.HasPrefix(p.src[p.pos:], "!1") {
+			p.pos += 2
+			return false, nil
+		}
+	default:
+		word := p.parseIdent()
+		switch word {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null", "undefined", "NaN", "Infinity":
+			return nil, nil
+		}
+		if word != "" {
+			return nil, p.errorf("unsupported javascript value %q", word)
+		}
+	}
+	return nil, p.errorf("unexpected %q", c)
+}
+
+func (p *jsparser) parseObject() (interface{}, error) {
+	res := make(map[string]interface{})
+	p.pos++
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated object")
+		}
+		if p.src[p.pos] == '}' {
+			p.pos++
+			return res, nil
+		}
+
+		var key string
+		switch c := p.src[p.pos]; {
+		case c == '"' || c == '\'' || c == '`':
+			v, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			key = v
+		case c >= '0' && c <= '9':
+			v, err := p.parseNumber()
+			if err != nil {
+				return nil, err
+			}
+			key = v.(json.Number).String()
+		default:
+			key = p.parseIdent()
+			if key == "" {
+				return nil, p.errorf("invalid object key %q", c)
+			}
+		}
+
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] != ':' {
+			return nil, p.errorf("expected ':' after key %q", key)
+		}
+		p.pos++
+
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		res[key] = val
+
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated object")
+		}
+		switch p.src[p.pos] {
+		case ',':
+			p.pos++
+		case '}':
+		default:
+			return nil, p.errorf("expected ',' or '}' in object")
+		}
+	}
+}
+
+func (p *jsparser) parseArray() (interface{}, error) {
+	res := make([]interface{}, 0)
+	p.pos++
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.src[p.pos] == ']' {
+			p.pos++
+			return res, nil
+		}
+
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, val)
+
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated array")
+		}
+		switch p.src[p.pos] {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+func (p *jsparser) parseIdent() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (p.pos > start && c >= '0' && c <= '9') {
+			p.pos++
+			continue
+		}
+		if c >= utf8.RuneSelf {
+			_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			p.pos += size
+			continue
+		}
+		break
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *jsparser) parseNumber() (interface{}, error) {
+	start := p.pos
+	for _, inf := range []string{"Infinity", "-Infinity", "+Infinity"} {
+		if strings.HasPrefix(p.src[p.pos:], inf) {
+			p.pos += len(inf)
+			return nil, nil
+		}
+	}
+	for p.pos < len(p.src) && strings.IndexByte("+-.0123456789abcdefABCDEFxXoO_", p.src[p.pos]) >= 0 {
+		// only consume a sign at the start or after an exponent
+		if c := p.src[p.pos]; (c == '+' || c == '-') && p.pos > start {
+			if prev := p.src[p.pos-1]; prev != 'e' && prev != 'E' {
+				break
+			}
+		}
+		p.pos++
+	}
+	text := strings.Replace(p.src[start:p.pos], "_", "", -1)
+
+	neg := false
+	digits := text
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		neg = digits[0] == '-'
+		digits = digits[1:]
+	}
+	if len(digits) > 2 && digits[0] == '0' && strings.IndexByte("xXoObB", digits[1]) >= 0 {
+		n, ok := new(big.Int).SetString(digits, 0)
+		if !ok {
+			return nil, p.errorf("invalid number %q", text)
+		}
+		if neg {
+			n.Neg(n)
+		}
+		return json.Number(n.String()), nil
+	}
+	f, err := strconv.ParseFloat(digits, 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %q", text)
+	}
+	if neg {
+		f = -f
+	}
+	if strings.ContainsAny(digits, ".eE") {
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	}
+	if neg {
+		digits = "-" + strings.TrimLeft(digits, "0")
+	} else {
+		digits = strings.TrimLeft(digits, "0")
+	}
+	if digits == "" || digits == "-" {
+		digits = "0"
+	}
+	return json.Number(digits), nil
+}
+
+func (p *jsparser) parseString() (string, error) {
+	quote := p.src[p.pos]
+	p.pos++
+	buf := make([]byte, 0, 16)
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == quote {
+			p.pos++
+			return string(buf), nil
+		}
+		if c == '\n' && quote != '`' {
+			return "", p.errorf("newline in string")
+		}
+		if c != '\\' {
+			buf = append(buf, c)
+			p.pos++
+			continue
+		}
+
+		p.pos++
+		if p.pos >= len(p.src) {
+			break
+		}
+		e := p.src[p.pos]
+		p.pos++
+		switch e {
+		case 'n':
+			buf = append(buf, '\n')
+		case 't':
+			buf = append(buf, '\t')
+		case 'r':
+			buf = append(buf, '\r')
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'v':
+			buf = append(buf, '\v')
+		case '0':
+			buf = append(buf, 0)
+		case '\r':
+			// line continuation
+			if p.pos < len(p.src) && p.src[p.pos] == '\n' {
+				p.pos++
+			}
+		case '\n':
+		case 'x':
+			if p.pos+2 > len(p.src) {
+				return "", p.errorf("invalid \\x escape")
+			}
+			n, err := strconv.ParseUint(p.src[p.pos:p.pos+2], 16, 8)
+			if err != nil {
+				return "", p.errorf("invalid \\x escape")
+			}
+			p.pos += 2
+			buf = utf8.AppendRune(buf, rune(n))
+		case 'u':
+			r, err := p.parseUnicodeEscape()
+			if err != nil {
+				return "", err
+			}
+			buf = utf8.AppendRune(buf, r)
+		default:
+			buf = append(buf, e)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *jsparser) parseUnicodeEscape() (rune, error) {
+	if p.pos < len(p.src) && p.src[p.pos] == '{' {
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return 0, p.errorf("invalid \\u escape")
+		}
+		n, err := strconv.ParseUint(p.src[p.pos+1:p.pos+end], 16, 32)
+		if err != nil {
+			return 0, p.errorf("invalid \\u escape")
+		}
+		p.pos += end + 1
+		return rune(n), nil
+	}
+
+	if p.pos+4 > len(p.src) {
+		return 0, p.errorf("invalid \\u escape")
+	}
+	n, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 16)
+	if err != nil {
+		return 0, p.errorf("invalid \\u escape")
+	}
+	p.pos += 4
+	r := rune(n)
+	if utf16.IsSurrogate(r) && strings.HasPrefix(p.src[p.pos:], "\\u") && p.pos+6 <= len(p.src) {
+		if n2, err := strconv.ParseUint(p.src[p.pos+2:p.pos+6], 16, 16); err == nil {
+			if r2 := utf16.DecodeRune(r, rune(n2)); r2 != utf8.RuneError {
+				p.pos += 6
+				return r2, nil
+			}
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,79 @@
+package gopiper
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJsLiteral(t *testing.T) {
+	body := `
+	<script>
+	// state rendered by the server
+	window.__INITIAL_STATE__ = {
+		user: {name: 'Tom', 'age': 18, tags: ["a", 'b\'c',], vip: !0},
+		list: [{id: 0x10, price: .5}, {id: 2, price: 1e2, note: undefined},],
+	};
+	var conf = JSON.parse("{\"debug\":false}");
+	jQuery1234_5678({"total": 2});
+	</script>`
+
+	res, err := js2json(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bd, _ := json.Marshal(res)
+	expect := `{"__INITIAL_STATE__":{"list":[{"id":16,"price":0.5},{"id":2,"note":null,"price":100}],"user":{"age":18,"name":"Tom","tags":["a","b'c"],"vip":true}},"callback":{"total":2},"conf":{"debug":false},"jQuery1234_5678":{"total":2}}`
+	if string(bd) != expect {
+		t.Fatalf("unexpected js result: %s", bd)
+	}
+
+	// dotted targets with the same last part do not overwrite each other
+	res, err = js2json(`window.a.data = {id: 1}; window.b.data = {id: 2}; window.a.data = {id: 3}; var list = [1]; app.list = [2]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bd, _ = json.Marshal(res)
+	expect = `{"a.data":{"id":3},"app.list":[2],"b.data":{"id":2},"data":{"id":3},"list":[1]}`
+	if string(bd) != expect {
+		t.Fatalf("unexpected dotted js result: %s", bd)
+	}
+}
+
+func TestJsPipe(t *testing.T) {
+	pipe := PipeItem{}
+	err := json.Unmarshal([]byte(`
+		{
+			"type": "map",
+			"subitem": [
+				{"name": "name", "type": "string", "selector": "__INITIAL_STATE__.user.name"},
+				{"name": "tags", "type": "string-array", "selector": "__INITIAL_STATE__.user.tags"},
+				{"name": "total", "type": "int", "selector": "callback.total"}
+			]
+		}
+	`), &pipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := pipe.PipeBytes([]byte(`var __INITIAL_STATE__ = {user: {name: "Tom", tags: ['x', 'y']}}; cb({total: 3})`), PAGE_JS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"name":  "Tom",
+		"tags":  []string{"x", "y"},
+		"total": int64(3),
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected js pipe result: %#v", val)
+	}
+
+	jpipe := PipeItem{Type: PT_INT, Selector: "$['b.data'].id"}
+	val, err = jpipe.PipeBytes([]byte(`window.a.data = {id: 1}; window.b.data = {id: 2}`), PAGE_JS)
+	if err != nil || val != int64(2) {
+		t.Fatalf("unexpected dotted js selector result: %#v %v", val, err)
+	}
+}
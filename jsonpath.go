@@ -0,0 +1,1075 @@
+package gopiper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// jsonpath is a compiled JSONPath query (RFC 9535). A selector that does not
+// start with `$` is read relative to the current value in the old dotted
+// syntax: keys may hold any character but `.` and `[`, and `this` names the
+// current value, so `this.value[2].data`, `items[*].sku`, `data..price` and
+// `list[?(@.type=='video')]` all work.
+type jsonpath struct {
+	segments []*jpsegment
+}
+
+type jpsegment struct {
+	descendant bool
+	selectors  []*jpselector
+}
+
+const (
+	jpName = iota
+	jpWildcard
+	jpIndex
+	jpSlice
+	jpFilter
+)
+
+type jpselector struct {
+	kind   int
+	name   string
+	index  int
+	slice  [3]*int // start, end, step
+	filter jplogic
+}
+
+// singular reports a query that can select at most one value: only name and
+// index selectors, no descendant segments.
+func (jp *jsonpath) singular() bool {
+	for _, seg := range jp.segments {
+		if seg.descendant || len(seg.selectors) != 1 {
+			return false
+		}
+		if k := seg.selectors[0].kind; k != jpName && k != jpIndex {
+			return false
+		}
+	}
+	return true
+}
+
+// query returns the nodelist selected from cur; root is what `$` means in
+// filter expressions.
+func (jp *jsonpath) query(root, cur interface{}) []interface{} {
+	nodes := []interface{}{cur}
+	for _, seg := range jp.segments {
+		out := make([]interface{}, 0)
+		for _, node := range nodes {
+			if seg.descendant {
+				jpdescend(node, func(d interface{}) {
+					for _, sel := range seg.selectors {
+						out = sel.apply(root, d, out)
+					}
+				})
+			} else {
+				for _, sel := range seg.selectors {
+					out = sel.apply(root, node, out)
+				}
+			}
+		}
+		nodes = out
+	}
+	return nodes
+}
+
+// value runs the query on v: a singular query gives its value or nil, any
+// other query the list of selected values.
+func (jp *jsonpath) value(v interface{}) interface{} {
+	nodes := jp.query(v, v)
+	if jp.singular() {
+		if len(nodes) == 0 {
+			return nil
+		}
+		return nodes[0]
+	}
+	return nodes
+}
+
+func jpdescend(node interface{}, fn func(interface{})) {
+	fn(node)
+	switch v := node.(type) {
+	case []interface{}:
+		for _, child := range v {
+			jpdescend(child, fn)
+		}
+	case map[string]interface{}:
+		for _, k := range jpkeys(v) {
+			jpdescend(v[k], fn)
+		}
+	}
+}
+
+// jpkeys gives object members a stable order, Go maps have none.
+func jpkeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func jpchildren(node interface{}) []interface{} {
+	switch v := node.(type) {
+	case []interface{}:
+		return v
+	case map[string]interface{}:
+		res := make([]interface{}, 0, len(v))
+		for _, k := range jpkeys(v) {
+			res = append(res, v[k])
+		}
+		return res
+	}
+	return nil
+}
+
+func (sel *jpselector) apply(root, node interface{}, out []interface{}) []interface{} {
+	switch sel.kind {
+	case jpName:
+		if m, ok := node.(map[string]interface{}); ok {
+			if v, has := m[sel.name]; has {
+				out = append(out, v)
+			}
+		}
+	case jpWildcard:
+		out = append(out, jpchildren(node)...)
+	case jpIndex:
+		if a, ok := node.([]interface{}); ok {
+			i := sel.index
+			if i < 0 {
+				i += len(a)
+			}
+			if i >= 0 && i < len(a) {
+				out = append(out, a[i])
+			}
+		}
+	case jpSlice:
+		if a, ok := node.([]interface{}); ok {
+			for _, i := range jpslice(len(a), sel.slice) {
+				out = append(out, a[i])
+			}
+		}
+	case jpFilter:
+		for _, child := range jpchildren(node) {
+			if sel.filter.test(root, child) {
+				out = append(out, child)
+			}
+		}
+	}
+	return out
+}
+
+// jpslice returns the indexes selected by start:end:step, RFC 9535 2.3.4.2.
+func jpslice(n int, slice [3]*int) []int {
+	step := 1
+	if slice[2] != nil {
+		step = *slice[2]
+	}
+	res := make([]int, 0)
+	if step == 0 {
+		return res
+	}
+
+	normalize := func(i int) int {
+		if i >= 0 {
+			return i
+		}
+		return n + i
+	}
+	clamp := func(i, lo, hi int) int {
+		if i < lo {
+			return lo
+		}
+		if i > hi {
+			return hi
+		}
+		return i
+	}
+
+	if step > 0 {
+		start, end := 0, n
+		if slice[0] != nil {
+			start = normalize(*slice[0])
+		}
+		if slice[1] != nil {
+			end = normalize(*slice[1])
+		}
+		lower, upper := clamp(start, 0, n), clamp(end, 0, n)
+		for i := lower; i < upper; i += step {
+			res = append(res, i)
+		}
+	} else {
+		start, end := n-1, -n-1
+		if slice[0] != nil {
+			start = normalize(*slice[0])
+		}
+		if slice[1] != nil {
+			end = normalize(*slice[1])
+		}
+		upper, lower := clamp(start, -1, n-1), clamp(end, -1, n-1)
+		for i := upper; lower < i; i += step {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// filter expressions
+
+type jplogic interface {
+	test(root, cur interface{}) bool
+}
+
+type jpor []jplogic
+
+func (e jpor) test(root, cur interface{}) bool {
+	for _, sub := range e {
+		if sub.test(root, cur) {
+			return true
+		}
+	}
+	return false
+}
+
+type jpand []jplogic
+
+func (e jpand) test(root, cur interface{}) bool {
+	for _, sub := range e {
+		if !sub.test(root, cur) {
+			return false
+		}
+	}
+	return true
+}
+
+type jpnot struct {
+	expr jplogic
+}
+
+func (e jpnot) test(root, cur interface{}) bool {
+	return !e.expr.test(root, cur)
+}
+
+// jpexists is a filter query used as a test: true when it selects anything.
+type jpexists struct {
+	query *jpquery
+}
+
+func (e jpexists) test(root, cur interface{}) bool {
+	return len(e.query.nodes(root, cur)) > 0
+}
+
+type jpfunctest struct {
+	fn *jpfunc
+}
+
+func (e jpfunctest) test(root, cur interface{}) bool {
+	v := e.fn.call(root, cur)
+	if nodes, ok := v.([]interface{}); ok && e.fn.result == jpNodesType {
+		return len(nodes) > 0
+	}
+	b, ok := v.(bool)
+	return ok && b
+}
+
+type jpcompare struct {
+	op   string
+	l, r *jparg
+}
+
+func (e jpcompare) test(root, cur interface{}) bool {
+	l, r := e.l.value(root, cur), e.r.value(root, cur)
+	switch e.op {
+	case "==":
+		return jpequal(l, r)
+	case "!=":
+		return !jpequal(l, r)
+	case "<":
+		return jpless(l, r)
+	case "<=":
+		return jpless(l, r) || jpequal(l, r)
+	case ">":
+		return jpless(r, l)
+	case ">=":
+		return jpless(r, l) || jpequal(l, r)
+	}
+	return false
+}
+
+// jpnothing is the result of a singular query that selects nothing. It only
+// equals itself.
+type jpnothing struct{}
+
+type jpquery struct {
+	absolute bool
+	path     *jsonpath
+}
+
+func (q *jpquery) nodes(root, cur interface{}) []interface{} {
+	if q.absolute {
+		return q.path.query(root, root)
+	}
+	return q.path.query(root, cur)
+}
+
+const (
+	jpLiteralArg = iota
+	jpQueryArg
+	jpFuncArg
+)
+
+// jparg is an operand of a comparison or a function argument.
+type jparg struct {
+	kind    int
+	literal interface{}
+	query   *jpquery
+	fn      *jpfunc
+}
+
+// value gives the ValueType of the operand, jpnothing{} when there is none.
+func (a *jparg) value(root, cur interface{}) interface{} {
+	switch a.kind {
+	case jpLiteralArg:
+		return a.literal
+	case jpQueryArg:
+		nodes := a.query.nodes(root, cur)
+		if len(nodes) == 1 {
+			return nodes[0]
+		}
+		return jpnothing{}
+	case jpFuncArg:
+		return a.fn.call(root, cur)
+	}
+	return jpnothing{}
+}
+
+func (a *jparg) nodes(root, cur interface{}) []interface{} {
+	if a.kind == jpQueryArg {
+		return a.query.nodes(root, cur)
+	}
+	return nil
+}
+
+const (
+	jpValueType = iota
+	jpLogicalType
+	jpNodesType
+)
+
+type jpfunc struct {
+	name    string
+	args    []*jparg
+	result  int
+	pattern *regexp.Regexp // match/search pattern given as a literal
+}
+
+func (f *jpfunc) call(root, cur interface{}) interface{} {
+	switch f.name {
+	case "length":
+		switch v := f.args[0].value(root, cur).(type) {
+		case string:
+			return json.Number(strconv.Itoa(utf8.RuneCountInString(v)))
+		case []interface{}:
+			return json.Number(strconv.Itoa(len(v)))
+		case map[string]interface{}:
+			return json.Number(strconv.Itoa(len(v)))
+		}
+		return jpnothing{}
+	case "count":
+		return json.Number(strconv.Itoa(len(f.args[0].nodes(root, cur))))
+	case "value":
+		nodes := f.args[0].nodes(root, cur)
+		if len(nodes) == 1 {
+			return nodes[0]
+		}
+		return jpnothing{}
+	case "match", "search":
+		str, ok := f.args[0].value(root, cur).(string)
+		if !ok {
+			return false
+		}
+		exp := f.pattern
+		if exp == nil {
+			pattern, ok := f.args[1].value(root, cur).(string)
+			if !ok {
+				return false
+			}
+			var err error
+			if exp, err = compileIRegexp(pattern, f.name == "match"); err != nil {
+				return false
+			}
+		}
+		return exp.MatchString(str)
+	}
+	return jpnothing{}
+}
+
+// compileIRegexp turns an I-Regexp (RFC 9485) into a Go regexp. The only
+// difference that matters is `.`, which must not match \r either.
+func compileIRegexp(pattern string, full bool) (*regexp.Regexp, error) {
+	buf := make([]byte, 0, len(pattern)+8)
+	escaped, class := false, false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case class:
+			class = c != ']'
+		case c == '[':
+			class = true
+		case c == '.':
+			buf = append(buf, `[^\n\r]`...)
+			continue
+		}
+		buf = append(buf, c)
+	}
+	if full {
+		return compileRegexp(`\A(?:` + string(buf) + `)\z`)
+	}
+	return compileRegexp(string(buf))
+}
+
+func jpnumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func jpequal(l, r interface{}) bool {
+	if ln, ok := jpnumber(l); ok {
+		rn, ok := jpnumber(r)
+		return ok && ln == rn
+	}
+	switch lv := l.(type) {
+	case jpnothing:
+		_, ok := r.(jpnothing)
+		return ok
+	case []interface{}:
+		rv, ok := r.([]interface{})
+		if !ok || len(lv) != len(rv) {
+			return false
+		}
+		for i := range lv {
+			if !jpequal(lv[i], rv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		rv, ok := r.(map[string]interface{})
+		if !ok || len(lv) != len(rv) {
+			return false
+		}
+		for k, v := range lv {
+			if rvv, has := rv[k]; !has || !jpequal(v, rvv) {
+				return false
+			}
+		}
+		return true
+	}
+	if _, ok := r.(jpnothing); ok {
+		return false
+	}
+	return reflect.DeepEqual(l, r)
+}
+
+func jpless(l, r interface{}) bool {
+	if ln, ok := jpnumber(l); ok {
+		rn, ok := jpnumber(r)
+		return ok && ln < rn
+	}
+	if ls, ok := l.(string); ok {
+		rs, ok := r.(string)
+		return ok && ls < rs
+	}
+	return false
+}
+
+// parser
+
+type jpparser struct {
+	src    string
+	pos    int
+	legacy bool
+}
+
+func (p *jpparser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("jsonpath: column %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func compileJsonPath(selector string) (*jsonpath, error) {
+	p := &jpparser{src: selector}
+	if strings.HasPrefix(selector, "$") {
+		p.pos = 1
+	} else {
+		p.legacy = true
+	}
+
+	jp, err := p.parsePath(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos:])
+	}
+	return jp, nil
+}
+
+func (p *jpparser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *jpparser) skipBlank() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *jpparser) consume(s string) bool {
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+// parsePath reads the segments after `$` or `@`. Inside a filter it stops at
+// the first character that does not start a segment.
+func (p *jpparser) parsePath(filter bool) (*jsonpath, error) {
+	jp := &jsonpath{}
+	first := true
+	for p.pos < len(p.src) {
+		start := p.pos
+		if !p.legacy || filter {
+			p.skipBlank()
+		}
+
+		var (
+			seg *jpsegment
+			err error
+		)
+		switch {
+		case p.consume(".."):
+			seg, err = p.parseDescendant(filter)
+		case p.consume("."):
+			seg, err = p.parseDotted(filter)
+		case p.peek() == '[':
+			seg, err = p.parseBracketed()
+		case p.legacy && first && !filter:
+			seg, err = p.parseDotted(filter)
+		default:
+			p.pos = start
+			return jp, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if seg != nil {
+			jp.segments = append(jp.segments, seg)
+		}
+		first = false
+	}
+	return jp, nil
+}
+
+func (p *jpparser) parseDescendant(filter bool) (*jpsegment, error) {
+	seg := &jpsegment{descendant: true}
+	switch {
+	case p.peek() == '[':
+		bracketed, err := p.parseBracketed()
+		if err != nil {
+			return nil, err
+		}
+		seg.selectors = bracketed.selectors
+	case p.consume("*"):
+		seg.selectors = []*jpselector{{kind: jpWildcard}}
+	default:
+		name := p.parseName(filter)
+		if name == "" {
+			return nil, p.errorf("expect name after '..'")
+		}
+		seg.selectors = []*jpselector{{kind: jpName, name: name}}
+	}
+	return seg, nil
+}
+
+// parseDotted reads `.name` or `.*`; a legacy `this` selects nothing new and
+// gives a nil segment.
+func (p *jpparser) parseDotted(filter bool) (*jpsegment, error) {
+	if p.consume("*") {
+		return &jpsegment{selectors: []*jpselector{{kind: jpWildcard}}}, nil
+	}
+	name := p.parseName(filter)
+	if name == "" {
+		return nil, p.errorf("expect member name")
+	}
+	if p.legacy && !filter && name == "this" {
+		return nil, nil
+	}
+	return &jpsegment{selectors: []*jpselector{{kind: jpName, name: name}}}, nil
+}
+
+// parseName reads a member-name-shorthand. Outside filters a legacy selector
+// allows every character but `.` and `[` in names.
+func (p *jpparser) parseName(filter bool) string {
+	start := p.pos
+	if p.legacy && !filter {
+		for p.pos < len(p.src) && p.src[p.pos] != '.' && p.src[p.pos] != '[' {
+			p.pos++
+		}
+		return p.src[start:p.pos]
+	}
+
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (p.pos > start && c >= '0' && c <= '9') {
+			p.pos++
+			continue
+		}
+		if c >= utf8.RuneSelf {
+			_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			p.pos += size
+			continue
+		}
+		break
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *jpparser) parseBracketed() (*jpsegment, error) {
+	seg := &jpsegment{}
+	p.pos++ // [
+	for {
+		p.skipBlank()
+		sel, err := p.parseSelector()
+		if err != nil {
+			return nil, err
+		}
+		seg.selectors = append(seg.selectors, sel)
+		p.skipBlank()
+		if p.consume("]") {
+			return seg, nil
+		}
+		if !p.consume(",") {
+			return nil, p.errorf("expect ',' or ']'")
+		}
+	}
+}
+
+func (p *jpparser) parseSelector() (*jpselector, error) {
+	switch c := p.peek(); {
+	case c == '\'' || c == '"':
+		name, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return &jpselector{kind: jpName, name: name}, nil
+	case c == '*':
+		p.pos++
+		return &jpselector{kind: jpWildcard}, nil
+	case c == '?':
+		p.pos++
+		p.skipBlank()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return &jpselector{kind: jpFilter, filter: expr}, nil
+	case c == '-' || c == ':' || (c >= '0' && c <= '9'):
+		return p.parseIndexOrSlice()
+	case c == 0:
+		return nil, p.errorf("unexpected end of selector")
+	}
+	return nil, p.errorf("invalid selector %q", p.peek())
+}
+
+func (p *jpparser) parseInt() (*int, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(p.src[start:p.pos], 10, 64)
+	if err != nil || n > 1<<53-1 || n < -(1<<53-1) {
+		p.pos = start
+		return nil, p.errorf("invalid integer %q", p.src[start:p.pos])
+	}
+	v := int(n)
+	return &v, nil
+}
+
+func (p *jpparser) parseIndexOrSlice() (*jpselector, error) {
+	var slice [3]*int
+	for i := 0; i < 3; i++ {
+		p.skipBlank()
+		n, err := p.parseInt()
+		if err != nil {
+			return nil, err
+		}
+		slice[i] = n
+		p.skipBlank()
+		if i == 0 && p.peek() != ':' {
+			if n == nil {
+				return nil, p.errorf("expect index")
+			}
+			return &jpselector{kind: jpIndex, index: *n}, nil
+		}
+		if i < 2 && !p.consume(":") {
+			break
+		}
+	}
+	return &jpselector{kind: jpSlice, slice: slice}, nil
+}
+
+func (p *jpparser) parseString() (string, error) {
+	quote := p.src[p.pos]
+	p.pos++
+	buf := make([]byte, 0, 16)
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == quote {
+			p.pos++
+			return string(buf), nil
+		}
+		if c != '\\' {
+			buf = append(buf, c)
+			p.pos++
+			continue
+		}
+		p.pos++
+		if p.pos >= len(p.src) {
+			break
+		}
+		e := p.src[p.pos]
+		p.pos++
+		switch e {
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case '/', '\\', '\'', '"':
+			buf = append(buf, e)
+		case 'u':
+			r, err := p.parseHex4()
+			if err != nil {
+				return "", err
+			}
+			if utf16.IsSurrogate(r) && p.consume(`\u`) {
+				r2, err := p.parseHex4()
+				if err != nil {
+					return "", err
+				}
+				r = utf16.DecodeRune(r, r2)
+			}
+			buf = utf8.AppendRune(buf, r)
+		default:
+			return "", p.errorf("invalid escape '\\%c'", e)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *jpparser) parseHex4() (rune, error) {
+	if p.pos+4 > len(p.src) {
+		return 0, p.errorf("invalid \\u escape")
+	}
+	n, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 16)
+	if err != nil {
+		return 0, p.errorf("invalid \\u escape")
+	}
+	p.pos += 4
+	return rune(n), nil
+}
+
+func (p *jpparser) parseOr() (jplogic, error) {
+	expr := jpor{}
+	for {
+		sub, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		expr = append(expr, sub)
+		p.skipBlank()
+		if !p.consume("||") {
+			break
+		}
+		p.skipBlank()
+	}
+	if len(expr) == 1 {
+		return expr[0], nil
+	}
+	return expr, nil
+}
+
+func (p *jpparser) parseAnd() (jplogic, error) {
+	expr := jpand{}
+	for {
+		sub, err := p.parseBasic()
+		if err != nil {
+			return nil, err
+		}
+		expr = append(expr, sub)
+		p.skipBlank()
+		if !p.consume("&&") {
+			break
+		}
+		p.skipBlank()
+	}
+	if len(expr) == 1 {
+		return expr[0], nil
+	}
+	return expr, nil
+}
+
+func (p *jpparser) parseBasic() (jplogic, error) {
+	p.skipBlank()
+	if p.peek() == '!' && !strings.HasPrefix(p.src[p.pos:], "!=") {
+		p.pos++
+		p.skipBlank()
+		expr, err := p.parseBasic()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := expr.(jpcompare); ok {
+			return nil, p.errorf("a comparison can not be negated without parentheses")
+		}
+		return jpnot{expr}, nil
+	}
+
+	if p.consume("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipBlank()
+		if !p.consume(")") {
+			return nil, p.errorf("expect ')'")
+		}
+		return jpparen{expr}, nil
+	}
+
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if !p.consume(op) {
+			continue
+		}
+		p.skipBlank()
+		r, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkComparable(l); err != nil {
+			return nil, err
+		}
+		if err := p.checkComparable(r); err != nil {
+			return nil, err
+		}
+		return jpcompare{op, l, r}, nil
+	}
+
+	switch l.kind {
+	case jpQueryArg:
+		return jpexists{l.query}, nil
+	case jpFuncArg:
+		if l.fn.result == jpValueType {
+			return nil, p.errorf("function %s() can not be used as a test", l.fn.name)
+		}
+		return jpfunctest{l.fn}, nil
+	}
+	return nil, p.errorf("a literal can not be used as a test")
+}
+
+// jpparen keeps parenthesized expressions apart from bare comparisons, which
+// RFC 9535 does not allow to be negated.
+type jpparen struct {
+	expr jplogic
+}
+
+func (e jpparen) test(root, cur interface{}) bool {
+	return e.expr.test(root, cur)
+}
+
+func (p *jpparser) checkComparable(a *jparg) error {
+	switch a.kind {
+	case jpQueryArg:
+		if !a.query.path.singular() {
+			return p.errorf("only singular queries can be compared")
+		}
+	case jpFuncArg:
+		if a.fn.result != jpValueType {
+			return p.errorf("function %s() can not be compared", a.fn.name)
+		}
+	}
+	return nil
+}
+
+func (p *jpparser) parseOperand() (*jparg, error) {
+	switch c := p.peek(); {
+	case c == '@' || c == '$':
+		p.pos++
+		saved := p.legacy
+		p.legacy = false
+		path, err := p.parsePath(true)
+		p.legacy = saved
+		if err != nil {
+			return nil, err
+		}
+		return &jparg{kind: jpQueryArg, query: &jpquery{absolute: c == '$', path: path}}, nil
+	case c == '\'' || c == '"':
+		s, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return &jparg{kind: jpLiteralArg, literal: s}, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case c >= 'a' && c <= 'z':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || (p.src[p.pos] >= 'a' && p.src[p.pos] <= 'z') || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+			p.pos++
+		}
+		word := p.src[start:p.pos]
+		if p.peek() == '(' {
+			return p.parseFunction(word)
+		}
+		switch word {
+		case "true":
+			return &jparg{kind: jpLiteralArg, literal: true}, nil
+		case "false":
+			return &jparg{kind: jpLiteralArg, literal: false}, nil
+		case "null":
+			return &jparg{kind: jpLiteralArg, literal: nil}, nil
+		}
+		p.pos = start
+		return nil, p.errorf("unknown literal %q", word)
+	}
+	return nil, p.errorf("invalid operand %q", p.peek())
+}
+
+func (p *jpparser) parseNumber() (*jparg, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0 {
+		if c := p.src[p.pos]; (c == '+' || c == '-') && p.src[p.pos-1] != 'e' && p.src[p.pos-1] != 'E' {
+			break
+		}
+		p.pos++
+	}
+	text := p.src[start:p.pos]
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsInf(f, 0) {
+		return nil, p.errorf("invalid number %q", text)
+	}
+	return &jparg{kind: jpLiteralArg, literal: json.Number(text)}, nil
+}
+
+var jpfuncs = map[string]struct {
+	args   []int
+	result int
+}{
+	"length": {[]int{jpValueType}, jpValueType},
+	"count":  {[]int{jpNodesType}, jpValueType},
+	"match":  {[]int{jpValueType, jpValueType}, jpLogicalType},
+	"search": {[]int{jpValueType, jpValueType}, jpLogicalType},
+	"value":  {[]int{jpNodesType}, jpValueType},
+}
+
+func (p *jpparser) parseFunction(name string) (*jparg, error) {
+	def, ok := jpfuncs[name]
+	if !ok {
+		return nil, p.errorf("unknown function %s()", name)
+	}
+	p.pos++ // (
+
+	fn := &jpfunc{name: name, result: def.result}
+	for {
+		p.skipBlank()
+		if p.consume(")") {
+			break
+		}
+		if len(fn.args) > 0 {
+			if !p.consume(",") {
+				return nil, p.errorf("expect ',' or ')'")
+			}
+			p.skipBlank()
+		}
+		arg, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		fn.args = append(fn.args, arg)
+	}
+
+	if len(fn.args) != len(def.args) {
+		return nil, p.errorf("function %s() takes %d arguments", name, len(def.args))
+	}
+	for i, arg := range fn.args {
+		switch def.args[i] {
+		case jpValueType:
+			if err := p.checkComparable(arg); err != nil {
+				return nil, err
+			}
+		case jpNodesType:
+			if arg.kind != jpQueryArg {
+				return nil, p.errorf("function %s() needs a query argument", name)
+			}
+		}
+	}
+
+	if (name == "match" || name == "search") && fn.args[1].kind == jpLiteralArg {
+		pattern, ok := fn.args[1].literal.(string)
+		if !ok {
+			return nil, p.errorf("function %s() needs a string pattern", name)
+		}
+		exp, err := compileIRegexp(pattern, name == "match")
+		if err != nil {
+			return nil, p.errorf("function %s(): %v", name, err)
+		}
+		fn.pattern = exp
+	}
+	return &jparg{kind: jpFuncArg, fn: fn}, nil
+}
@@ -0,0 +1,139 @@
+package gopiper
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/bitly/go-simplejson"
+)
+
+const testJsonPathBody = `{
+	"store": {
+		"book": [
+			{"category": "reference", "author": "Nigel Rees", "title": "Sayings of the Century", "price": 8.95},
+			{"category": "fiction", "author": "Evelyn Waugh", "title": "Sword of Honour", "price": 12.99},
+			{"category": "fiction", "author": "Herman Melville", "title": "Moby Dick", "isbn": "0-553-21311-3", "price": 8.99},
+			{"category": "fiction", "author": "J. R. R. Tolkien", "title": "The Lord of the Rings", "isbn": "0-395-19395-8", "price": 22.99}
+		],
+		"bicycle": {"color": "red", "price": 399}
+	},
+	"a.b": 1,
+	"value": [[0, 1], [2, 3], [4, [5, 6]]]
+}`
+
+func TestJsonPath(t *testing.T) {
+	js, err := simplejson.NewJson([]byte(testJsonPathBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		selector string
+		expect   string
+	}{
+		// old syntax
+		{"store.bicycle.color", `"red"`},
+		{"this.value[2][1]", `[5,6]`},
+		{"value[2].this", `[4,[5,6]]`},
+		{"store.none", `null`},
+		// RFC 9535
+		{"$", ``},
+		{"$.store.book[*].author", `["Nigel Rees","Evelyn Waugh","Herman Melville","J. R. R. Tolkien"]`},
+		{"$..author", `["Nigel Rees","Evelyn Waugh","Herman Melville","J. R. R. Tolkien"]`},
+		{"store..price", `[399,8.95,12.99,8.99,22.99]`},
+		{"$.store.book[-1].title", `"The Lord of the Rings"`},
+		{"$.store.book[1:3].price", `[12.99,8.99]`},
+		{"$.store.book[::-2].price", `[22.99,12.99]`},
+		{"$.store.book[0,2]['title']", `["Sayings of the Century","Moby Dick"]`},
+		{"$['a.b']", `1`},
+		{"$.store.book[?@.isbn].title", `["Moby Dick","The Lord of the Rings"]`},
+		{"store.book[?(@.price < 10)].title", `["Sayings of the Century","Moby Dick"]`},
+		{"store.book[?(@.category=='fiction' && @.price > 20 || @.author == \"Nigel Rees\")].price", `[8.95,22.99]`},
+		{"store.book[?!(@.category=='fiction')].price", `[8.95]`},
+		{"store.book[?@.price > $.store.book[2].price].price", `[12.99,22.99]`},
+		{"store.book[?length(@.title) == 9].title", `["Moby Dick"]`},
+		{"store.book[?match(@.author, 'H.*')].author", `["Herman Melville"]`},
+		{"store.book[?search(@.title, 'of')].price", `[8.95,12.99,22.99]`},
+		{"$.store[?count(@.*) == 2].color", `["red"]`},
+		{"$.store.book[?value(@..isbn) == '0-553-21311-3'].price", `[8.99]`},
+	}
+
+	for _, test := range tests {
+		res, err := parseJsonSelector(js, test.selector)
+		if err != nil {
+			t.Fatalf("%s: %v", test.selector, err)
+		}
+		if test.expect == "" {
+			if !reflect.DeepEqual(res.Interface(), js.Interface()) {
+				t.Fatalf("%s: expect the whole document", test.selector)
+			}
+			continue
+		}
+		bd, _ := json.Marshal(res.Interface())
+		if string(bd) != test.expect {
+			t.Fatalf("%s: unexpected result %s, expect %s", test.selector, bd, test.expect)
+		}
+	}
+}
+
+func TestJsonPathError(t *testing.T) {
+	for _, selector := range []string{
+		"$.store[",
+		"$.store.book[?@.price]]",
+		"$.store.book[?@..price == 1]",
+		"$.store.book[?!@.price == 1]",
+		"$.store.book[?length(@.price)]",
+		"$.store.book[?count(1) == 1]",
+		"$.store.book[?foo(@)]",
+		"$.store.book['title]",
+		"$.store.book[?match(@.title, '(')]",
+	} {
+		if _, err := compileJsonSelector(selector); err == nil {
+			t.Fatalf("%s: expect a compile error", selector)
+		}
+	}
+}
+
+func TestJsonPathPipe(t *testing.T) {
+	pipe := PipeItem{}
+	err := json.Unmarshal([]byte(`
+		{
+			"type": "map",
+			"subitem": [
+				{"name": "skus", "type": "string-array", "selector": "items[*].sku"},
+				{"name": "first", "type": "string", "selector": "items[*].sku"},
+				{"name": "prices", "type": "json", "selector": "data..price"},
+				{
+					"name": "videos",
+					"type": "array",
+					"selector": "list[?(@.type=='video')]",
+					"subitem": [{"type": "int", "selector": "id"}]
+				}
+			]
+		}
+	`), &pipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{
+		"items": [{"sku": "a1"}, {"sku": "b2"}],
+		"data": {"price": 1, "sub": [{"price": 2}]},
+		"list": [{"type": "video", "id": 1}, {"type": "image", "id": 2}, {"type": "video", "id": 3}]
+	}`
+	val, err := pipe.PipeBytes([]byte(body), PAGE_JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"skus":   []string{"a1", "b2"},
+		"first":  "a1",
+		"prices": []interface{}{json.Number("1"), json.Number("2")},
+		"videos": []interface{}{int64(1), int64(3)},
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected jsonpath pipe result: %#v", val)
+	}
+}
@@ -0,0 +1,454 @@
+package gopiper
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// numberFormat names the separators of the numbers written on a page and
+// the units that multiply them.
+type numberFormat struct {
+	decimal  rune
+	grouping string
+	units    map[rune]float64
+}
+
+// cn_number_units are the multipliers the typed rules expand: 3.2万.
+var cn_number_units = map[rune]float64{'万': 1e4, '萬': 1e4, '亿': 1e8, '億': 1e8}
+
+// number_units are the multipliers of the number filters, which also read
+// 1.5k or 2M; the 100W of a power rating is not 1000000 for an int rule.
+var number_units = map[rune]float64{
+	'k': 1e3, 'K': 1e3, 'w': 1e4, 'W': 1e4, 'M': 1e6, 'B': 1e9,
+	'千': 1e3, '万': 1e4, '萬': 1e4, '亿': 1e8, '億': 1e8,
+}
+
+// default_number_format reads 1,234.5, 1 234.5 and 1'234.5 for the typed
+// rules, intval and floatval.
+var default_number_format = numberFormat{decimal: '.', grouping: ", '_\u00a0\u202f", units: cn_number_units}
+
+// sbcDigit maps the full width digits and signs of CJK pages to ascii.
+func sbcDigit(r rune) rune {
+	if r >= '０' && r <= '９' || r == '．' || r == '，' || r == '－' || r == '＋' {
+		return r - 65248
+	}
+	if r == '−' {
+		return '-'
+	}
+	return r
+}
+
+// normalizeNumber finds the first number in s, such as the 99.00 of
+// "¥ 99.00" or the 3.2万 of "播放3.2万次", and returns it without grouping
+// separators in Go syntax with the multiplier of its unit. Text without
+// arabic digits is read as chinese numerals: 十二, 三万五千.
+func normalizeNumber(s string, f numberFormat) (string, float64, error) {
+	rs := []rune(s)
+	for i, r := range rs {
+		rs[i] = sbcDigit(r)
+	}
+
+	start := -1
+	for i, r := range rs {
+		if r >= '0' && r <= '9' || r == f.decimal && i+1 < len(rs) && rs[i+1] >= '0' && rs[i+1] <= '9' {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		if v, ok := parseCnNumber(s); ok {
+			return strconv.FormatFloat(v, 'f', -1, 64), 1, nil
+		}
+		return "", 0, errors.New("no number in '" + s + "'")
+	}
+
+	var b strings.Builder
+	if start > 0 && (rs[start-1] == '-' || rs[start-1] == '+') {
+		b.WriteRune(rs[start-1])
+	}
+	decimal := false
+	i := start
+	for ; i < len(rs); i++ {
+		r := rs[i]
+		next := i+1 < len(rs) && rs[i+1] >= '0' && rs[i+1] <= '9'
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+			continue
+		case r == f.decimal && !decimal && next:
+			decimal = true
+			b.WriteByte('.')
+			continue
+		case strings.ContainsRune(f.grouping, r) && !decimal && next:
+			if digitGroup(rs[i+1:]) {
+				continue
+			}
+			// 1,5 may be a decimal comma, but the space of "Top 10 2023"
+			// just ends the number
+			if r == ',' || r == '.' {
+				return "", 0, errors.New("misplaced separator in '" + s + "'")
+			}
+		}
+		break
+	}
+	// 1e3, 2.5E-3
+	if i < len(rs) && (rs[i] == 'e' || rs[i] == 'E') {
+		j := i + 1
+		if j < len(rs) && (rs[j] == '-' || rs[j] == '+') {
+			j++
+		}
+		if j < len(rs) && rs[j] >= '0' && rs[j] <= '9' {
+			b.WriteByte('e')
+			b.WriteString(string(rs[i+1 : j]))
+			for i = j; i < len(rs) && rs[i] >= '0' && rs[i] <= '9'; i++ {
+				b.WriteRune(rs[i])
+			}
+		}
+	}
+
+	mult := 1.0
+	for i < len(rs) && rs[i] == ' ' {
+		i++
+	}
+	if i < len(rs) {
+		if m, ok := f.units[rs[i]]; ok {
+			// 5 min or 3 Mbps are not a multiplier
+			if rs[i] >= 0x80 || i+1 == len(rs) || !unicode.IsLetter(rs[i+1]) {
+				mult = m
+			}
+		}
+	}
+	return b.String(), mult, nil
+}
+
+// digitGroup tells whether rs starts with exactly three digits.
+func digitGroup(rs []rune) bool {
+	if len(rs) < 3 {
+		return false
+	}
+	for _, r := range rs[:3] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return len(rs) == 3 || rs[3] < '0' || rs[3] > '9'
+}
+
+// parseNumber reads the first number of s, see normalizeNumber. integral is
+// true when the value has no fraction.
+func parseNumber(s string, f numberFormat) (v float64, integral bool, err error) {
+	num, mult, err := normalizeNumber(s, f)
+	if err != nil {
+		return 0, false, err
+	}
+	v, err = strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	v *= mult
+	if mult != 1 {
+		// 3.2万 is 32000, not 32000.000000000004
+		v = math.Round(v*1e6) / 1e6
+	}
+	return v, v == math.Trunc(v), nil
+}
+
+// parseInteger is parseNumber for the int types; the digits of a plain
+// integer are parsed as they are, so large ids keep their precision.
+func parseInteger(s string, f numberFormat) (int64, error) {
+	num, mult, err := normalizeNumber(s, f)
+	if err != nil {
+		return 0, err
+	}
+	if mult == 1 && !strings.ContainsAny(num, ".e") {
+		return strconv.ParseInt(num, 10, 64)
+	}
+	v, integral, err := parseNumber(s, f)
+	if err != nil {
+		return 0, err
+	}
+	if !integral {
+		return 0, errors.New("'" + strings.TrimSpace(s) + "' is not an integer")
+	}
+	return int64(v), nil
+}
+
+var cn_digits = map[rune]int{
+	'零': 0, '〇': 0, '一': 1, '壹': 1, '二': 2, '贰': 2, '两': 2, '三': 3, '叁': 3, '四': 4, '肆': 4,
+	'五': 5, '伍': 5, '六': 6, '陆': 6, '七': 7, '柒': 7, '八': 8, '捌': 8, '九': 9, '玖': 9,
+}
+
+var cn_units = map[rune]int{'十': 10, '拾': 10, '百': 100, '佰': 100, '千': 1000, '仟': 1000}
+
+var cn_sections = map[rune]float64{'万': 1e4, '萬': 1e4, '亿': 1e8, '億': 1e8}
+
+func isCnNumeral(r rune) bool {
+	_, digit := cn_digits[r]
+	_, unit := cn_units[r]
+	_, section := cn_sections[r]
+	return digit || unit || section || r == '点' || r >= '0' && r <= '9'
+}
+
+// parseCnNumber reads the first chinese numeral of s: 十二, 一百零五,
+// 三万五千, 二〇一七, 三点五. Arabic digits may be mixed in, as in 3万. A
+// numeral starts with a digit or 十, so the 百 of 百度 or the 万 of 万科 is
+// not one.
+func parseCnNumber(s string) (float64, bool) {
+	all := []rune(s)
+	var rs []rune
+	for start := 0; start < len(all); {
+		for start < len(all) && !isCnNumeral(all[start]) {
+			start++
+		}
+		end := start
+		for end < len(all) && isCnNumeral(all[end]) {
+			end++
+		}
+		if start < end && (cnDigit(all[start]) >= 0 || all[start] == '十' || all[start] == '拾') {
+			rs = all[start:end]
+			break
+		}
+		start = end
+	}
+	if len(rs) == 0 {
+		return 0, false
+	}
+
+	var fraction []rune
+	for i, r := range rs {
+		if r == '点' {
+			rs, fraction = rs[:i], rs[i+1:]
+			break
+		}
+	}
+
+	var total, section, last float64
+	digit, plain := -1, true
+	for _, r := range rs {
+		if _, ok := cn_units[r]; ok {
+			plain = false
+		}
+		if _, ok := cn_sections[r]; ok {
+			plain = false
+		}
+	}
+	if plain {
+		// 二〇一七 is read digit by digit
+		for _, r := range rs {
+			total = total*10 + float64(cnDigit(r))
+		}
+	} else {
+		for _, r := range rs {
+			if d, ok := cn_digits[r]; ok {
+				digit = d
+				continue
+			}
+			if r >= '0' && r <= '9' {
+				if digit < 0 {
+					digit = 0
+				}
+				digit = digit*10 + int(r-'0')
+				continue
+			}
+			if u, ok := cn_units[r]; ok {
+				if digit < 0 {
+					digit = 1 // 十二
+				}
+				section += float64(digit * u)
+				digit = -1
+				continue
+			}
+			if m, ok := cn_sections[r]; ok {
+				if digit > 0 {
+					section += float64(digit)
+				}
+				if m < last {
+					// the 五千万 of 一亿五千万 is below the 亿 read so far
+					total += section * m
+				} else {
+					total = (total + section) * m
+					last = m
+				}
+				section, digit = 0, -1
+			}
+		}
+		if digit > 0 {
+			section += float64(digit)
+		}
+		total += section
+	}
+
+	if len(fraction) > 0 {
+		scale := 0.1
+		for _, r := range fraction {
+			d := cnDigit(r)
+			if d < 0 {
+				break
+			}
+			total += float64(d) * scale
+			scale /= 10
+		}
+	}
+	return total, true
+}
+
+func cnDigit(r rune) int {
+	if d, ok := cn_digits[r]; ok {
+		return d
+	}
+	if r >= '0' && r <= '9' {
+		return int(r - '0')
+	}
+	return -1
+}
+
+// numberFormatArgs reads the (decimal, grouping) arguments of the number
+// filters, e.g. number(',', '.') for 1.234,5.
+func numberFormatArgs(args []FilterArg) (numberFormat, error) {
+	f := default_number_format
+	f.units = number_units
+	if len(args) > 0 {
+		d := []rune(args[0].String())
+		if len(d) != 1 {
+			return f, errors.New("decimal separator must be one character: " + args[0].Raw)
+		}
+		f.decimal = d[0]
+		f.grouping = strings.Replace(f.grouping, string(f.decimal), ".", -1)
+	}
+	if len(args) > 1 {
+		f.grouping = args[1].String()
+	}
+	return f, nil
+}
+
+// numberValue is an int64 for an integral number, a float64 otherwise.
+func numberValue(v float64, integral bool) interface{} {
+	if integral && math.Abs(v) < 1<<63 {
+		return int64(v)
+	}
+	return v
+}
+
+// mapNumbers runs fn over a string or each string of a list.
+func mapNumbers(src *reflect.Value, fn func(s string) (interface{}, error)) (interface{}, error) {
+	switch vt := src.Interface().(type) {
+	case string:
+		return fn(vt)
+	case []string:
+		res := make([]interface{}, len(vt))
+		for i, s := range vt {
+			v, err := fn(s)
+			if err != nil {
+				return src.Interface(), err
+			}
+			res[i] = v
+		}
+		return res, nil
+	}
+	return src.Interface(), nil
+}
+
+// number(decimal, grouping) reads "1,234", "¥ 99.00", "3.2万", "1.5k" or
+// "十二" as a number.
+func number(src *reflect.Value, args []FilterArg) (interface{}, error) {
+	f, err := numberFormatArgs(args)
+	if err != nil {
+		return src.Interface(), err
+	}
+	return mapNumbers(src, func(s string) (interface{}, error) {
+		v, integral, err := parseNumber(s, f)
+		if err != nil {
+			return nil, err
+		}
+		return numberValue(v, integral), nil
+	})
+}
+
+// cnnum reads chinese numerals: 十二, 一百零五, 三万五千, 二〇一七.
+func cnnum(src *reflect.Value, args []FilterArg) (interface{}, error) {
+	return mapNumbers(src, func(s string) (interface{}, error) {
+		v, ok := parseCnNumber(s)
+		if !ok {
+			return nil, errors.New("no chinese number in '" + s + "'")
+		}
+		return numberValue(v, v == math.Trunc(v)), nil
+	})
+}
+
+// price_currencies are matched in order, longer symbols first.
+var price_currencies = []struct{ symbol, code string }{
+	{"US$", "USD"}, {"HK$", "HKD"}, {"NT$", "TWD"}, {"A$", "AUD"}, {"C$", "CAD"},
+	{"RMB", "CNY"}, {"CNY", "CNY"}, {"USD", "USD"}, {"EUR", "EUR"}, {"GBP", "GBP"},
+	{"JPY", "JPY"}, {"HKD", "HKD"}, {"KRW", "KRW"},
+	{"¥", "CNY"}, {"￥", "CNY"}, {"元", "CNY"}, {"$", "USD"}, {"€", "EUR"}, {"£", "GBP"},
+	{"円", "JPY"}, {"₩", "KRW"}, {"₹", "INR"}, {"₽", "RUB"},
+}
+
+// price(decimal, grouping) reads "¥ 99.00" or "US$1,299" as a map with the
+// amount and the ISO code of the currency, empty when none is written.
+func price(src *reflect.Value, args []FilterArg) (interface{}, error) {
+	f, err := numberFormatArgs(args)
+	if err != nil {
+		return src.Interface(), err
+	}
+	return mapNumbers(src, func(s string) (interface{}, error) {
+		v, _, err := parseNumber(s, f)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"amount": v, "currency": priceCurrency(s, f)}, nil
+	})
+}
+
+// priceCurrency finds the currency written right before or after the first
+// number of s, past its unit: ¥ 99, 99元, US$1,299, 3万 RMB. The 5元 of
+// "5元/斤, EUROPE" is CNY, and a code only counts as a whole word.
+func priceCurrency(s string, f numberFormat) string {
+	rs := []rune(strings.ToUpper(s))
+	for i, r := range rs {
+		rs[i] = sbcDigit(r)
+	}
+	isDigit := func(i int) bool { return i < len(rs) && rs[i] >= '0' && rs[i] <= '9' }
+
+	start := 0
+	for start < len(rs) && !isDigit(start) {
+		start++
+	}
+	if start == len(rs) {
+		return ""
+	}
+	end := start
+	for isDigit(end) || end+1 < len(rs) && (rs[end] == f.decimal || strings.ContainsRune(f.grouping, rs[end])) && isDigit(end+1) {
+		end++
+	}
+
+	before := strings.TrimRight(string(rs[:start]), " +-\u00a0")
+	after := strings.TrimLeft(string(rs[end:]), " \u00a0")
+	if r := []rune(after); len(r) > 0 {
+		if _, ok := f.units[r[0]]; ok {
+			after = strings.TrimLeft(string(r[1:]), " \u00a0")
+		}
+	}
+
+	isLetter := func(r rune) bool { return r >= 'A' && r <= 'Z' }
+	for _, c := range price_currencies {
+		sym := []rune(c.symbol)
+		if strings.HasSuffix(before, c.symbol) {
+			rest := []rune(strings.TrimSuffix(before, c.symbol))
+			if !isLetter(sym[0]) || len(rest) == 0 || !isLetter(rest[len(rest)-1]) {
+				return c.code
+			}
+		}
+		if strings.HasPrefix(after, c.symbol) {
+			rest := []rune(strings.TrimPrefix(after, c.symbol))
+			if !isLetter(sym[len(sym)-1]) || len(rest) == 0 || !isLetter(rest[0]) {
+				return c.code
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,167 @@
+package gopiper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := map[string]float64{
+		"1,234":        1234,
+		"¥ 99.00":      99,
+		"3.2万":         32000,
+		"播放3.2万次":      32000,
+		"1.5k":         1.5,
+		"2.5M views":   2.5,
+		"5 min":        5,
+		"功率 100W":      100,
+		"-12.5%":       -12.5,
+		"１２３４":         1234,
+		"1 234 567.8":  1234567.8,
+		".5":           0.5,
+		"十二":           12,
+		"一百零五":         105,
+		"三万五千":         35000,
+		"二〇一七年":        2017,
+		"三点一四":         3.14,
+		"共两千零一十条":      2010,
+		"百度十二":         12,
+		"1.2亿":         120000000,
+		"一亿五千万":        150000000,
+		"三亿二千万五千":      320005000,
+		"一万亿":          1000000000000,
+		"十二亿零三万":       1200030000,
+		"US$1,299.99":  1299.99,
+		"Price: 12.30": 12.3,
+		"1e3":          1000,
+		"2.5E-3":       0.0025,
+		"-1.5e+2 m":    -150,
+		"3 eggs":       3,
+		"5e":           5,
+	}
+	for s, expect := range tests {
+		v, _, err := parseNumber(s, default_number_format)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if v != expect {
+			t.Fatalf("%s: unexpected %v, expect %v", s, v, expect)
+		}
+	}
+
+	for _, s := range []string{"none", "百度", "万科股份", "千万不要", "点击"} {
+		if v, _, err := parseNumber(s, default_number_format); err == nil {
+			t.Fatalf("%s: expect error without a number, got %v", s, v)
+		}
+	}
+	if v, err := parseInteger("12345678901234567", default_number_format); err != nil || v != 12345678901234567 {
+		t.Fatalf("unexpected large integer: %v %v", v, err)
+	}
+	if v, err := parseInteger("1e3", default_number_format); err != nil || v != 1000 {
+		t.Fatalf("unexpected exponent integer: %v %v", v, err)
+	}
+	if _, err := parseInteger("1.5", default_number_format); err == nil {
+		t.Fatal("expect error for a fraction")
+	}
+}
+
+func TestNumberTypes(t *testing.T) {
+	html := `<ul><li>1,234</li><li>¥ 99.00</li><li>3.2万</li><li>100W</li><li>十二</li></ul>`
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "ints", Type: PT_INT_ARRAY, Selector: "li"},
+		{Name: "floats", Type: PT_FLOAT_ARRAY, Selector: "li"},
+		{Name: "first", Type: PT_INT, Selector: "li|first"},
+		{Name: "number", Type: PT_STRING_ARRAY, Selector: "li", Filter: "number"},
+		{Name: "intval", Type: PT_STRING, Selector: "li|eq(2)", Filter: "intval"},
+		{Name: "floatval", Type: PT_STRING, Selector: "li|eq(1)", Filter: "floatval"},
+	}}
+	val, err := pipe.PipeBytes([]byte(html), PAGE_HTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"ints":     []int64{1234, 99, 32000, 100, 12},
+		"floats":   []float64{1234, 99, 32000, 100, 12},
+		"first":    int64(1234),
+		"number":   []interface{}{int64(1234), int64(99), int64(32000), int64(1000000), int64(12)},
+		"intval":   32000,
+		"floatval": 99.0,
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected number result: %#v", val)
+	}
+
+	jpipe := PipeItem{Type: PT_FLOAT, Selector: "price"}
+	val, err = jpipe.PipeBytes([]byte(`{"price": "1,299.50 元"}`), PAGE_JSON)
+	if err != nil || val != 1299.5 {
+		t.Fatalf("unexpected json number: %#v %v", val, err)
+	}
+}
+
+func TestNumberFilters(t *testing.T) {
+	tests := []struct {
+		src    interface{}
+		filter string
+		expect interface{}
+	}{
+		{"1.234,56 €", `number(',')`, 1234.56},
+		{"1 234,5", `number(',', ' ')`, 1234.5},
+		{"1'234.5", `number`, 1234.5},
+		{"12.30", `number`, 12.3},
+		{"1.5k", `number`, int64(1500)},
+		{"2.5M views", `number`, int64(2500000)},
+		{"3千", `number`, int64(3000)},
+		{"2.5E-3", `floatval`, 0.0025},
+		{"1e3", `intval`, 1000},
+		{"第十二集", `cnnum`, int64(12)},
+		{"一亿五千万", `cnnum`, int64(150000000)},
+		{[]string{"三", "二十"}, `cnnum`, []interface{}{int64(3), int64(20)}},
+		{"¥ 99.00", `price`, map[string]interface{}{"amount": 99.0, "currency": "CNY"}},
+		{"US$1,299", `price`, map[string]interface{}{"amount": 1299.0, "currency": "USD"}},
+		{"€ 1.299,90", `price(',')`, map[string]interface{}{"amount": 1299.9, "currency": "EUR"}},
+		{"88", `price`, map[string]interface{}{"amount": 88.0, "currency": ""}},
+		{"5元/斤, EUROPE", `price`, map[string]interface{}{"amount": 5.0, "currency": "CNY"}},
+		{"EUROPE: 5", `price`, map[string]interface{}{"amount": 5.0, "currency": ""}},
+		{"3万 rmb", `price`, map[string]interface{}{"amount": 30000.0, "currency": "CNY"}},
+		{"100 USDT", `price`, map[string]interface{}{"amount": 100.0, "currency": ""}},
+		{"售价 ￥ 12", `price`, map[string]interface{}{"amount": 12.0, "currency": "CNY"}},
+		{"HK$ 1,000 起", `price`, map[string]interface{}{"amount": 1000.0, "currency": "HKD"}},
+	}
+	for _, test := range tests {
+		val, err := callFilter(test.src, test.filter)
+		if err != nil {
+			t.Fatalf("%v %s: %v", test.src, test.filter, err)
+		}
+		if !reflect.DeepEqual(val, test.expect) {
+			t.Fatalf("%v %s: unexpected %#v", test.src, test.filter, val)
+		}
+	}
+
+	pipe := PipeItem{Type: PT_STRING, Selector: "b", Filter: "number(',,')"}
+	if _, res, _ := MustCompile(pipe).Extract([]byte(`<b>1</b>`), PAGE_HTML, Options{}); len(res.Errors) != 1 {
+		t.Fatalf("expect separator error, got %v", res)
+	}
+
+	// unreadable numbers give the typed zero and a report entry
+	pipe = PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "int", Type: PT_STRING, Selector: "b", Filter: "intval"},
+		{Name: "float", Type: PT_STRING, Selector: "b", Filter: "floatval"},
+	}}
+	val, res, err := MustCompile(pipe).Extract([]byte(`<b>n/a</b>`), PAGE_HTML, Options{})
+	if err != nil || !reflect.DeepEqual(val, map[string]interface{}{"int": 0, "float": 0.0}) || len(res.Errors) != 2 {
+		t.Fatalf("unexpected unreadable number result: %#v %v %v", val, res, err)
+	}
+
+	// a separator is only grouping before three digits; a misplaced comma
+	// is an error, a space or ' ends the number
+	for _, s := range []string{"1,5", "12,34", "1,2345"} {
+		if _, err := parseInteger(s, default_number_format); err == nil {
+			t.Fatalf("%s: expect separator error", s)
+		}
+	}
+	for s, expect := range map[string]int64{"1, 2, 3": 1, "Top 10 2023": 10, "5 12": 5, "1'23": 1, "1 234 567": 1234567} {
+		if v, err := parseInteger(s, default_number_format); err != nil || v != expect {
+			t.Fatalf("%s: unexpected result %v %v", s, v, err)
+		}
+	}
+}
@@ -1,15 +1,20 @@
 package gopiper
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
 	"github.com/bitly/go-simplejson"
+	"golang.org/x/net/html"
 )
 
 const (
@@ -26,6 +31,7 @@ const (
 	PT_ARRAY        = "array"
 	PT_JSON_VALUE   = "json"
 	PT_JSON_PARSE   = "jsonparse"
+	PT_CONST        = "const"
 	// end new version
 
 	// begin compatible old version
@@ -54,180 +60,286 @@ type PipeItem struct {
 	Type     string     `json:"type"`
 	Filter   string     `json:"filter,omitempty"`
 	SubItem  []PipeItem `json:"subitem,omitempty"`
+
+	// Required makes a miss of this field a failure of the map or array
+	// element that holds it, reported once. Default is used instead of a
+	// miss, converted to the rule type for int, float and bool rules; every
+	// run gets its own copy of it, and OmitEmpty leaves a missing or empty field
+	// out of its map.
+	Required  bool        `json:"required,omitempty"`
+	Default   interface{} `json:"default,omitempty"`
+	OmitEmpty bool        `json:"omitempty,omitempty"`
+
+	// AbsUrl resolves the urls extracted by the href, src, attr and string
+	// types of an html page against the base url of the page.
+	AbsUrl bool `json:"absurl,omitempty"`
 }
 
 type htmlselector struct {
 	*goquery.Selection
 	attr     string
+	attrName string // x of an attr[x] suffix
 	selector string
 }
 
+// PipeBytes compiles the rule and runs it over body once. Rules that are run
+// more than once should be compiled with Compile and kept.
 func (p *PipeItem) PipeBytes(body []byte, pagetype string) (interface{}, error) {
-	switch pagetype {
-	case PAGE_HTML:
-		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
-		if err != nil {
-			return nil, err
-		}
-		return p.pipeSelection(doc.Selection)
-	case PAGE_JSON:
-		return p.pipeJson(body)
-	case PAGE_TEXT:
-		return p.pipeText(body)
+	pl, err := Compile(*p)
+	if err != nil {
+		return nil, err
 	}
-	return nil, nil
+	return pl.RunBytes(body, pagetype)
 }
 
-func (p *PipeItem) parseRegexp(body string) (interface{}, error) {
-	s := p.Selector[7:]
-	exp, err := regexp.Compile(s)
+// PipeBytesURL is PipeBytes for a page fetched from baseurl, which resolves
+// the urls of AbsUrl rules and the absurl filter.
+func (p *PipeItem) PipeBytesURL(body []byte, pagetype, baseurl string) (interface{}, error) {
+	pl, err := Compile(*p)
 	if err != nil {
 		return nil, err
 	}
+	val, _, err := pl.Extract(body, pagetype, Options{BaseURL: baseurl})
+	return val, err
+}
+
+func (n *pipeNode) parseRegexp(ctx *runContext, body string) (interface{}, error) {
+	if n.groups && (n.tp == PT_MAP || (n.tp == PT_ARRAY && len(n.subs) == 0)) {
+		return n.parseRegexpGroups(ctx, body)
+	}
 
-	sv := exp.FindStringSubmatch(body)
+	// sv holds the capture groups of the first match, or for regexpall: the
+	// value of every match; a match without groups is its own value
+	var sv []string
+	if n.expAll {
+		for _, m := range n.exp.FindAllStringSubmatch(body, -1) {
+			if len(m) > 1 {
+				sv = append(sv, m[1])
+			} else {
+				sv = append(sv, m[0])
+			}
+		}
+	} else {
+		sv = n.exp.FindStringSubmatch(body)
+		if len(sv) > 1 {
+			sv = sv[1:]
+		}
+	}
+	if sv == nil && n.missRule() {
+		return nil, notFound("Regexp can't Find match!: " + n.selector)
+	}
 	rs := ""
 
-	if len(sv) == 1 {
+	if len(sv) > 0 {
 		rs = sv[0]
-	} else if len(sv) > 1 {
-		rs = sv[1]
-		sv = sv[1:]
+	}
+	if sv == nil {
+		sv = make([]string, 0)
 	}
 
-	switch p.Type {
+	switch n.tp {
 	case PT_INT, PT_FLOAT, PT_BOOL:
-		val, err := parseTextValue(rs, p.Type)
+		val, err := parseTextValue(rs, n.tp)
 		if err != nil {
 			return nil, err
 		}
-		return callFilter(val, p.Filter)
+		return n.filter(ctx, val)
 	case PT_INT_ARRAY, PT_FLOAT_ARRAY, PT_BOOL_ARRAY:
-		val, err := parseTextValue(sv, p.Type)
+		val, err := parseTextValue(sv, n.tp)
 		if err != nil {
 			return nil, err
 		}
-		return callFilter(val, p.Filter)
+		return n.filter(ctx, val)
 	case PT_TEXT, PT_STRING:
-		return callFilter(rs, p.Filter)
+		return n.filter(ctx, rs)
 	case PT_TEXT_ARRAY, PT_STRING_ARRAY:
-		return callFilter(sv, p.Filter)
+		return n.filter(ctx, sv)
 	case PT_JSON_PARSE:
-		if p.SubItem == nil || len(p.SubItem) <= 0 {
-			return nil, errors.New("Pipe type jsonparse need one subItem!")
-		}
 		body, err := text2jsonbyte(rs)
 		if err != nil {
 			return nil, errors.New("jsonparse: text is not a json string" + err.Error())
 		}
-		parse_item := p.SubItem[0]
-		res, err := parse_item.pipeJson(body)
+		res, err := n.subs[0].pipeJson(ctx, body)
 		if err != nil {
 			return nil, err
 		}
-		return callFilter(res, p.Filter)
+		return n.filter(ctx, res)
 	case PT_JSON_VALUE:
 		res, err := text2json(rs)
 		if err != nil {
 			return nil, err
 		}
-		return callFilter(res, p.Filter)
-	case PT_MAP:
-		if p.SubItem == nil || len(p.SubItem) <= 0 {
-			return nil, errors.New("Pipe type array need one subItem!")
-		}
-		res := make(map[string]interface{})
-		for _, subitem := range p.SubItem {
-			if subitem.Name == "" {
-				continue
+		return n.filter(ctx, res)
+	case PT_ARRAY:
+		// every match is a text body for the array item
+		array_item := n.subs[0]
+		res := make([]interface{}, 0)
+		for _, match := range n.exp.FindAllString(body, -1) {
+			v, err := array_item.pipeText(ctx, []byte(match))
+			if err = ctx.collect(array_item, err); err != nil {
+				return nil, err
 			}
-			res[subitem.Name], _ = subitem.pipeText([]byte(rs))
+			res = append(res, v)
+		}
+		return n.filter(ctx, res)
+	case PT_MAP:
+		res, err := n.pipeMap(ctx, func(sub *pipeNode) (interface{}, error) {
+			return sub.pipeText(ctx, []byte(rs))
+		})
+		if err != nil {
+			return nil, err
 		}
-		return callFilter(res, p.Filter)
+		return n.filter(ctx, res)
 	}
-	return nil, errors.New("Not support pipe type")
+	return nil, unsupportTypeError(n.path, n.tp, "regexp")
 }
 
-func (p *PipeItem) pipeSelection(s *goquery.Selection) (interface{}, error) {
+// parseRegexpGroups builds maps keyed by the named groups of the regexp: a
+// map of the first match, or an array with a map for every match. A sub item
+// named like a group reads the group text, the others the whole match.
+func (n *pipeNode) parseRegexpGroups(ctx *runContext, body string) (interface{}, error) {
+	if n.tp == PT_MAP {
+		m := n.exp.FindStringSubmatch(body)
+		if m == nil {
+			return nil, notFound("Regexp can't Find match!: " + n.selector)
+		}
+		res, err := n.regexpGroups(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		return n.filter(ctx, res)
+	}
 
-	var (
-		sel = htmlselector{s, "", p.Selector}
-		err error
-	)
+	res := make([]interface{}, 0)
+	for _, m := range n.exp.FindAllStringSubmatch(body, -1) {
+		v, err := n.regexpGroups(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return n.filter(ctx, res)
+}
 
-	if strings.HasPrefix(p.Selector, "regexp:") {
-		body, _ := sel.Html()
-		return p.parseRegexp(body)
+func (n *pipeNode) regexpGroups(ctx *runContext, m []string) (map[string]interface{}, error) {
+	res := make(map[string]interface{})
+	for i, name := range n.exp.SubexpNames() {
+		if name != "" {
+			res[name] = m[i]
+		}
 	}
+	defer ctx.enterMap(res)()
 
-	selector := p.Selector
-	if selector != "" {
-		sel, err = parseHtmlSelector(s, selector)
-		if err != nil {
+	for _, sub := range n.subs {
+		if sub.name == "" {
+			continue
+		}
+		text := m[0]
+		if group, ok := res[sub.name].(string); ok {
+			text = group
+		}
+		v, err := sub.pipeText(ctx, []byte(text))
+		if err = ctx.collect(sub, err); err != nil {
 			return nil, err
 		}
+		if sub.omit(v) {
+			delete(res, sub.name)
+			continue
+		}
+		res[sub.name] = v
+	}
+	return res, nil
+}
+
+func (n *pipeNode) pipeSelection(ctx *runContext, s *goquery.Selection) (val interface{}, err error) {
+	defer n.settle(&val, &err)
+	defer ctx.edits.restore(len(ctx.edits.removed))
+
+	var sel = htmlselector{s, "", "", n.selector}
+
+	if isConstType(n.tp) {
+		return n.filter(ctx, n.selector)
+	}
+
+	if n.htmlErr != nil {
+		return nil, n.htmlErr
+	}
+
+	if n.exp != nil {
+		body, _ := sel.Html()
+		if n.html != nil {
+			// a combined selector: the regexp runs over the inner html, the
+			// text or an attribute of the selected nodes
+			sel = n.html.apply(ctx, s)
+			if sel.Size() == 0 {
+				return nil, notFound("Selector can't Find node!: " + sel.selector)
+			}
+			attr := sel.attr
+			if attr == "" {
+				attr = "html"
+			}
+			body, err = gethtmlattr(sel.Selection, attr, sel.attrName, sel.selector)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return n.parseRegexp(ctx, body)
+	}
+
+	selector := n.selector
+	if n.html != nil {
+		sel = n.html.apply(ctx, s)
 		selector = sel.selector
 	}
 
 	if sel.Size() == 0 {
-		return nil, errors.New("Selector can't Find node!: " + selector)
+		return nil, notFound("Selector can't Find node!: " + selector)
 	}
 
-	attr_exp, _ := regexp.Compile(PT_ATTR)
-	attr_array_exp, _ := regexp.Compile(PT_ATTR_ARRAY)
-
-	if attr_exp.MatchString(p.Type) {
-		vt := attr_exp.FindStringSubmatch(p.Type)
-		res, has := sel.Attr(vt[1])
+	if n.attr != "" {
+		res, has := sel.Attr(n.attr)
 		if !has {
-			return nil, errors.New("Can't Find attribute: " + p.Type + " selector: " + selector)
+			return nil, notFound("Can't Find attribute: " + n.tp + " selector: " + selector)
 		}
-		return callFilter(res, p.Filter)
-	} else if attr_array_exp.MatchString(p.Type) {
-		vt := attr_array_exp.FindStringSubmatch(p.Type)
+		return n.filter(ctx, n.absolute(ctx, res))
+	} else if n.attrArray != "" {
 		res := make([]string, 0)
 		sel.Each(func(index int, child *goquery.Selection) {
-			href, has := child.Attr(vt[1])
+			href, has := child.Attr(n.attrArray)
 			if has {
 				res = append(res, href)
 			}
 		})
-		return callFilter(res, p.Filter)
+		return n.filter(ctx, n.absolute(ctx, res))
 	}
 
-	switch p.Type {
-	case PT_INT, PT_FLOAT, PT_BOOL, PT_STRING, PT_TEXT, PT_INT_ARRAY, PT_FLOAT_ARRAY, PT_BOOL_ARRAY, PT_STRING_ARRAY:
-		val, err := parseHtmlAttr(sel, p.Type)
+	switch n.tp {
+	case PT_INT, PT_FLOAT, PT_BOOL, PT_STRING, PT_TEXT, PT_INT_ARRAY, PT_FLOAT_ARRAY, PT_BOOL_ARRAY, PT_STRING_ARRAY, PT_TEXT_ARRAY:
+		val, err := parseHtmlAttr(sel, n.tp)
 		if err != nil {
 			return nil, err
 		}
-		return callFilter(val, p.Filter)
+		return n.filter(ctx, n.absolute(ctx, val))
 	case PT_HTML:
 		html := ""
 		sel.Each(func(idx int, s1 *goquery.Selection) {
 			str, _ := s1.Html()
 			html += str
 		})
-		return callFilter(html, p.Filter)
+		return n.filter(ctx, html)
 	case PT_OUT_HTML:
 		html := ""
 		sel.Each(func(idx int, s1 *goquery.Selection) {
 			str, _ := goquery.OuterHtml(s1)
 			html += str
 		})
-		return callFilter(html, p.Filter)
+		return n.filter(ctx, html)
 	case PT_HREF, PT_IMG_SRC, PT_IMG_ALT:
-		res, has := sel.Attr(p.Type)
+		res, has := sel.Attr(n.tp)
 		if !has {
-			return nil, errors.New("Can't Find attribute: " + p.Type + " selector: " + selector)
+			return nil, notFound("Can't Find attribute: " + n.tp + " selector: " + selector)
 		}
-		return callFilter(res, p.Filter)
-	case PT_TEXT_ARRAY:
-		res := make([]string, 0)
-		sel.Each(func(index int, child *goquery.Selection) {
-			res = append(res, child.Text())
-		})
-		return callFilter(res, p.Filter)
+		return n.filter(ctx, n.absolute(ctx, res))
 	case PT_HREF_ARRAY:
 		res := make([]string, 0)
 		sel.Each(func(index int, child *goquery.Selection) {
@@ -236,72 +348,239 @@ func (p *PipeItem) pipeSelection(s *goquery.Selection) (interface{}, error) {
 				res = append(res, href)
 			}
 		})
-		return callFilter(res, p.Filter)
+		return n.filter(ctx, n.absolute(ctx, res))
 	case PT_ARRAY:
-		if p.SubItem == nil || len(p.SubItem) <= 0 {
-			return nil, errors.New("Pipe type array need one subItem!")
-		}
-		array_item := p.SubItem[0]
+		array_item := n.subs[0]
 		res := make([]interface{}, 0)
-		sel.Each(func(index int, child *goquery.Selection) {
-			v, _ := array_item.pipeSelection(child)
+		var err error
+		sel.EachWithBreak(func(index int, child *goquery.Selection) bool {
+			var v interface{}
+			v, err = array_item.pipeSelection(ctx, child)
+			if err = ctx.collect(array_item, err); err != nil {
+				return false
+			}
 			res = append(res, v)
+			return true
 		})
-		return callFilter(res, p.Filter)
+		if err != nil {
+			return nil, err
+		}
+		return n.filter(ctx, res)
 	case PT_MAP:
-		if p.SubItem == nil || len(p.SubItem) <= 0 {
-			return nil, errors.New("Pipe type array need one subItem!")
+		res, err := n.pipeMap(ctx, func(sub *pipeNode) (interface{}, error) {
+			return sub.pipeSelection(ctx, sel.Selection)
+		})
+		if err != nil {
+			return nil, err
 		}
-		res := make(map[string]interface{})
-		for _, subitem := range p.SubItem {
-			if subitem.Name == "" {
-				continue
+		return n.filter(ctx, res)
+	}
+
+	return nil, unsupportTypeError(n.path, n.tp, PAGE_HTML)
+}
+
+// htmlfunc is one `|fn(params)` step of an html selector.
+type htmlfunc struct {
+	name    string
+	params  string
+	index   int // eq(n), start of slice(a,b)
+	end     int
+	hasEnd  bool
+	matcher goquery.Matcher
+}
+
+const (
+	htmlNoArg = iota
+	htmlIndexArg
+	htmlRangeArg
+	htmlTextArg
+	htmlSelectorArg
+)
+
+// html_funcs lists the selector functions by the kind of their parameter.
+var html_funcs = map[string]int{
+	"first": htmlNoArg, "last": htmlNoArg, "next": htmlNoArg, "prev": htmlNoArg,
+	"nextall": htmlNoArg, "prevall": htmlNoArg, "siblings": htmlNoArg, "children": htmlNoArg,
+	"parent": htmlNoArg, "parents": htmlNoArg, "even": htmlNoArg, "odd": htmlNoArg,
+
+	"eq":    htmlIndexArg,
+	"slice": htmlRangeArg,
+
+	"contains": htmlTextArg,
+
+	"not": htmlSelectorArg, "filter": htmlSelectorArg, "has": htmlSelectorArg, "closest": htmlSelectorArg,
+	"nextuntil": htmlSelectorArg, "prevuntil": htmlSelectorArg,
+	"prevfilter": htmlSelectorArg, "prevallfilter": htmlSelectorArg, "nextfilter": htmlSelectorArg,
+	"nextallfilter": htmlSelectorArg, "parentfilter": htmlSelectorArg, "parentsfilter": htmlSelectorArg,
+	"childrenfilter": htmlSelectorArg, "siblingsfilter": htmlSelectorArg, "rm": htmlSelectorArg,
+}
+
+// htmlpipe is a parsed html selector: the css part, the function steps and
+// the `@attr` suffix that says what to extract, or an `xpath:` expression.
+type htmlpipe struct {
+	find     goquery.Matcher
+	funcs    []htmlfunc
+	attr     string
+	attrName string
+	xpath    *xpath.Expr
+	selector string
+}
+
+// splitHtmlSuffix cuts the `@value` or `//value` suffix off an html selector.
+// Quoted strings, brackets and parentheses are skipped, so `a[href^='//cdn']`
+// and `:contains(a@b)` stay css.
+func splitHtmlSuffix(selector string) (node, suffix string, ok bool) {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(selector); i++ {
+		c := selector[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			if depth > 0 {
+				depth--
 			}
-			res[subitem.Name], _ = subitem.pipeSelection(sel.Selection)
+		case depth > 0:
+		case c == '@':
+			return selector[:i], selector[i+1:], true
+		case c == '/' && i > 0 && i+1 < len(selector) && selector[i+1] == '/':
+			return selector[:i], selector[i+2:], true
 		}
-
-		return callFilter(res, p.Filter)
-	default:
-		return callFilter(0, p.Filter)
 	}
+	return selector, "", false
+}
 
-	return nil, errors.New("Not support pipe type")
+var html_suffix_exp = regexp.MustCompile(`^(?:((?i:text|html|outhtml|owntext|val|tagname))|attr\[(.+)\])$`)
+
+// parseHtmlSuffix checks the value named by a selector suffix:
+//
+//	text      text of the nodes and their descendants (the default)
+//	html      inner html
+//	outhtml   outer html
+//	ownText   text of the nodes themselves, without descendants
+//	val       value of a form control: input, textarea, select
+//	tagName   tag name of the first node
+//	attr[x]   attribute x
+func parseHtmlSuffix(suffix string) (attr, name string, err error) {
+	suffix = strings.TrimSpace(suffix)
+	m := html_suffix_exp.FindStringSubmatch(suffix)
+	if m == nil {
+		return "", "", errors.New("unknown html value '" + suffix + "', expect text, html, outhtml, ownText, val, tagName or attr[name]")
+	}
+	if m[1] != "" {
+		return strings.ToLower(m[1]), "", nil
+	}
+	return suffix, m[2], nil
 }
 
-func parseHtmlSelector(s *goquery.Selection, selector string) (htmlselector, error) {
-	attr := ""
+func compileHtmlSelector(selector string) (*htmlpipe, error) {
+	hp := &htmlpipe{selector: selector}
 	if selector == "" {
-		return htmlselector{s, attr, selector}, nil
+		return hp, nil
 	}
 
-	if idx := strings.Index(selector, "//"); idx > 0 {
-		attr = strings.TrimSpace(selector[idx+2:])
-		selector = strings.TrimSpace(selector[:idx])
+	if strings.HasPrefix(selector, "xpath:") {
+		expr, err := xpath.Compile(selector[6:])
+		if err != nil {
+			return nil, err
+		}
+		hp.xpath = expr
+		return hp, nil
 	}
 
-	subs := strings.Split(selector, "|")
-	if len(subs) < 1 {
-		return htmlselector{s.Find(selector), attr, selector}, nil
+	if node, suffix, ok := splitHtmlSuffix(selector); ok {
+		attr, name, err := parseHtmlSuffix(suffix)
+		if err != nil {
+			return nil, err
+		}
+		hp.attr, hp.attrName = attr, name
+		selector = strings.TrimSpace(node)
+		hp.selector = selector
+		if selector == "" {
+			return hp, nil
+		}
 	}
 
-	s = s.Find(subs[0])
-	exp, _ := regexp.Compile(`([a-z_]+)(\(([\w\W+]+)\))?`)
-	for i := 1; i < len(subs); i++ {
-		if !exp.MatchString(subs[i]) {
-			return htmlselector{s, attr, selector}, errors.New("error parse html selector: " + subs[i])
+	head, rest, piped := splitPipeHead(selector)
+	m, err := cascadia.Compile(strings.TrimSpace(head))
+	if err != nil {
+		return nil, err
+	}
+	hp.find = m
+	if !piped {
+		return hp, nil
+	}
+
+	calls, err := parsePipeCalls(rest, len(head)+1)
+	if err != nil {
+		return nil, errors.New("error parse html selector: " + err.Error())
+	}
+	for _, call := range calls {
+		fn := htmlfunc{name: call.name, params: strings.TrimSpace(unquoteParams(call.params))}
+
+		kind, ok := html_funcs[fn.name]
+		if !ok {
+			return nil, fmt.Errorf("column %d: unknown selector function: %s", call.col, fn.name)
+		}
+		switch kind {
+		case htmlIndexArg:
+			if fn.index, err = strconv.Atoi(fn.params); err != nil {
+				return nil, errors.New(fn.name + " need an integer index: " + fn.params)
+			}
+		case htmlRangeArg:
+			bounds := strings.Split(fn.params, ",")
+			if fn.index, err = strconv.Atoi(strings.TrimSpace(bounds[0])); err != nil || len(bounds) > 2 {
+				return nil, errors.New(fn.name + " need one or two integers: " + fn.params)
+			}
+			if len(bounds) == 2 {
+				fn.hasEnd = true
+				if fn.end, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+					return nil, errors.New(fn.name + " need one or two integers: " + fn.params)
+				}
+			}
+		case htmlSelectorArg:
+			if fn.params == "" {
+				return nil, errors.New(fn.name + " need a selector")
+			}
+			if fn.matcher, err = cascadia.Compile(fn.params); err != nil {
+				return nil, err
+			}
 		}
+		hp.funcs = append(hp.funcs, fn)
+	}
+	return hp, nil
+}
 
-		vt := exp.FindStringSubmatch(subs[i])
-		fn := vt[1]
-		params := ""
-		if len(vt) > 3 {
-			params = strings.TrimSpace(vt[3])
+func (hp *htmlpipe) apply(ctx *runContext, s *goquery.Selection) htmlselector {
+	if hp.xpath != nil {
+		// an empty selection of the same document; Slice shares the node
+		// array of s, so it must not be appended to
+		res := s.Slice(0, 0)
+		res.Nodes = nil
+		for _, node := range s.Nodes {
+			res = res.AddNodes(htmlquery.QuerySelectorAll(node, hp.xpath)...)
 		}
+		return htmlselector{res, "", "", hp.selector}
+	}
+
+	if hp.find == nil {
+		return htmlselector{s, hp.attr, hp.attrName, hp.selector}
+	}
 
-		switch fn {
+	s = s.FindMatcher(hp.find)
+	for _, fn := range hp.funcs {
+		switch fn.name {
 		case "eq":
-			pm, _ := strconv.Atoi(params)
-			s = s.Eq(pm)
+			s = s.Eq(fn.index)
 		case "next":
 			s = s.Next()
 		case "prev":
@@ -320,53 +599,163 @@ func parseHtmlSelector(s *goquery.Selection, selector string) (htmlselector, err
 			s = s.Parent()
 		case "parents":
 			s = s.Parents()
-		case "not":
-			if params != "" {
-				s = s.Not(params)
+		case "prevall":
+			s = s.PrevAll()
+		case "even", "odd":
+			rem := 0
+			if fn.name == "odd" {
+				rem = 1
 			}
+			s = s.FilterFunction(func(i int, _ *goquery.Selection) bool {
+				return i%2 == rem
+			})
+		case "slice":
+			s = sliceSelection(s, fn.index, fn.end, fn.hasEnd)
+		case "contains":
+			text := fn.params
+			s = s.FilterFunction(func(_ int, child *goquery.Selection) bool {
+				return strings.Contains(child.Text(), text)
+			})
+		case "has":
+			s = s.HasMatcher(fn.matcher)
+		case "closest":
+			s = s.ClosestMatcher(fn.matcher)
+		case "nextuntil":
+			s = s.NextUntilMatcher(fn.matcher)
+		case "prevuntil":
+			s = s.PrevUntilMatcher(fn.matcher)
+		case "not":
+			s = s.NotMatcher(fn.matcher)
 		case "filter":
-			if params != "" {
-				s = s.Filter(params)
-			}
+			s = s.FilterMatcher(fn.matcher)
 		case "prevfilter":
-			if params != "" {
-				s = s.PrevFiltered(params)
-			}
+			s = s.PrevMatcher(fn.matcher)
 		case "prevallfilter":
-			if params != "" {
-				s = s.PrevAllFiltered(params)
-			}
+			s = s.PrevAllMatcher(fn.matcher)
 		case "nextfilter":
-			if params != "" {
-				s = s.NextFiltered(params)
-			}
+			s = s.NextMatcher(fn.matcher)
 		case "nextallfilter":
-			if params != "" {
-				s = s.NextAllFiltered(params)
-			}
+			s = s.NextAllMatcher(fn.matcher)
 		case "parentfilter":
-			if params != "" {
-				s = s.ParentFiltered(params)
-			}
+			s = s.ParentMatcher(fn.matcher)
 		case "parentsfilter":
-			if params != "" {
-				s = s.ParentsFiltered(params)
-			}
+			s = s.ParentsMatcher(fn.matcher)
 		case "childrenfilter":
-			if params != "" {
-				s = s.ChildrenFiltered(params)
-			}
+			s = s.ChildrenMatcher(fn.matcher)
 		case "siblingsfilter":
-			if params != "" {
-				s = s.SiblingsFiltered(params)
-			}
+			s = s.SiblingsMatcher(fn.matcher)
 		case "rm":
-			if params != "" {
-				s.Find(params).Remove()
+			// remove from a copy, the document is shared by sibling rules
+			s = ctx.edits.editable(s)
+			ctx.edits.remove(s.FindMatcher(fn.matcher).Nodes)
+		}
+	}
+	return htmlselector{s, hp.attr, hp.attrName, hp.selector}
+}
+
+// htmlEdits is the copy of the html document that rm removes nodes from.
+// The document is copied at most once per run; the nodes a rule removes are
+// put back when the rule is done, so sibling rules and array items still see
+// the whole copy.
+type htmlEdits struct {
+	copies  map[*html.Node]*html.Node // original and copied nodes to their copy
+	removed []removedNode
+}
+
+// removedNode is where a removed node was, to put it back.
+type removedNode struct {
+	node, parent, next *html.Node
+}
+
+// editable selects the nodes of s in the copy of their document. Unlike
+// Selection.Clone the nodes keep their parents and siblings, so steps after
+// rm can still walk the tree.
+func (e *htmlEdits) editable(s *goquery.Selection) *goquery.Selection {
+	if e.copies == nil {
+		e.copies = make(map[*html.Node]*html.Node)
+	}
+	nodes := make([]*html.Node, 0, len(s.Nodes))
+	for _, n := range s.Nodes {
+		if _, ok := e.copies[n]; !ok {
+			root := n
+			for root.Parent != nil {
+				root = root.Parent
 			}
+			cloneNode(root, e.copies)
+		}
+		nodes = append(nodes, e.copies[n])
+	}
+	if len(nodes) == 0 {
+		return s
+	}
+	root := nodes[0]
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	return goquery.NewDocumentFromNode(root).Selection.Slice(0, 0).AddNodes(nodes...)
+}
+
+func (e *htmlEdits) remove(nodes []*html.Node) {
+	for _, n := range nodes {
+		if n.Parent != nil {
+			e.removed = append(e.removed, removedNode{n, n.Parent, n.NextSibling})
+			n.Parent.RemoveChild(n)
 		}
 	}
-	return htmlselector{s, attr, selector}, nil
+}
+
+// restore puts back the nodes removed after the first mark ones, last
+// removed first.
+func (e *htmlEdits) restore(mark int) {
+	for i := len(e.removed) - 1; i >= mark; i-- {
+		r := e.removed[i]
+		r.parent.InsertBefore(r.node, r.next)
+	}
+	e.removed = e.removed[:mark]
+}
+
+// cloneNode deep copies n and records every copy in copies, as the copy of
+// both the original and itself.
+func cloneNode(n *html.Node, copies map[*html.Node]*html.Node) *html.Node {
+	nn := &html.Node{
+		Type:      n.Type,
+		DataAtom:  n.DataAtom,
+		Data:      n.Data,
+		Namespace: n.Namespace,
+		Attr:      append([]html.Attribute(nil), n.Attr...),
+	}
+	copies[n] = nn
+	copies[nn] = nn
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		nn.AppendChild(cloneNode(c, copies))
+	}
+	return nn
+}
+
+// sliceSelection is Selection.Slice with negative bounds counted from the
+// end and clamped to the selection, like javascript's Array.slice.
+func sliceSelection(s *goquery.Selection, start, end int, hasEnd bool) *goquery.Selection {
+	n := s.Length()
+	if !hasEnd {
+		end = n
+	}
+	clamp := func(i int) int {
+		if i < 0 {
+			i += n
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > n {
+			return n
+		}
+		return i
+	}
+	start, end = clamp(start), clamp(end)
+	if start > end {
+		start = end
+	}
+	return s.Slice(start, end)
 }
 
 func parseTextValue(text interface{}, tp string) (interface{}, error) {
@@ -384,13 +773,13 @@ func parseTextValue(text interface{}, tp string) (interface{}, error) {
 func parseHtmlAttr(sel htmlselector, tp string) (interface{}, error) {
 	switch tp {
 	case PT_INT, PT_FLOAT, PT_BOOL, PT_TEXT, PT_STRING:
-		text, err := gethtmlattr(sel.Selection, sel.attr, sel.selector)
+		text, err := gethtmlattr(sel.Selection, sel.attr, sel.attrName, sel.selector)
 		if err != nil {
 			return nil, err
 		}
 		return parseTextValue(text, tp)
-	case PT_INT_ARRAY, PT_FLOAT_ARRAY, PT_BOOL_ARRAY, PT_STRING_ARRAY:
-		text, err := gethtmlattr_array(sel.Selection, sel.attr, sel.selector)
+	case PT_INT_ARRAY, PT_FLOAT_ARRAY, PT_BOOL_ARRAY, PT_STRING_ARRAY, PT_TEXT_ARRAY:
+		text, err := gethtmlattr_array(sel.Selection, sel.attr, sel.attrName, sel.selector)
 		if err != nil {
 			return nil, err
 		}
@@ -400,143 +789,267 @@ func parseHtmlAttr(sel htmlselector, tp string) (interface{}, error) {
 	return nil, errors.New("unknow html attr")
 }
 
-func gethtmlattr_array(sel *goquery.Selection, attr, selector string) ([]string, error) {
+func gethtmlattr_array(sel *goquery.Selection, attr, name, selector string) ([]string, error) {
 	res := make([]string, 0)
-	if attr == "" {
-		sel.Each(func(index int, child *goquery.Selection) {
-			res = append(res, child.Text())
-		})
-		return res, nil
-	}
+	sel.Each(func(index int, child *goquery.Selection) {
+		if text, has := htmlvalue(child, attr, name); has {
+			res = append(res, text)
+		}
+	})
+	return res, nil
+}
 
-	attr_exp, _ := regexp.Compile(PT_ATTR)
+func gethtmlattr(sel *goquery.Selection, attr, name, selector string) (string, error) {
+	res, has := htmlvalue(sel, attr, name)
+	if !has {
+		return "", notFound("Can't Find attribute: " + attr + " selector: " + selector)
+	}
+	return res, nil
+}
 
-	if attr_exp.MatchString(attr) {
-		vt := attr_exp.FindStringSubmatch(attr)
-		sel.Each(func(index int, child *goquery.Selection) {
-			text, has := child.Attr(vt[1])
-			if has {
-				res = append(res, text)
-			}
-		})
-		return res, nil
-	} else if attr == "html" {
-		sel.Each(func(idx int, s1 *goquery.Selection) {
-			str, _ := s1.Html()
-			res = append(res, str)
-		})
-		return res, nil
-	} else if attr == "outhtml" {
+// htmlvalue extracts what the selector suffix asks for; see parseHtmlSuffix.
+// name is the attribute of an attr[name] suffix. Texts and html are joined
+// over all nodes, the other values come from the first node. has is false
+// for a missing attribute.
+func htmlvalue(sel *goquery.Selection, attr, name string) (string, bool) {
+	if name != "" {
+		return sel.Attr(name)
+	}
+	switch attr {
+	case "", "text":
+		return sel.Text(), true
+	case "html", "outhtml", "owntext":
+		res := ""
 		sel.Each(func(idx int, s1 *goquery.Selection) {
-			str, _ := goquery.OuterHtml(s1)
-			res = append(res, str)
+			switch attr {
+			case "html":
+				str, _ := s1.Html()
+				res += str
+			case "outhtml":
+				str, _ := goquery.OuterHtml(s1)
+				res += str
+			default:
+				s1.Contents().Each(func(_ int, c *goquery.Selection) {
+					if goquery.NodeName(c) == "#text" {
+						res += c.Text()
+					}
+				})
+			}
 		})
-		return res, nil
+		return res, true
+	case "val":
+		return htmlval(sel.First()), true
+	case "tagname":
+		return goquery.NodeName(sel), true
 	}
 
-	return res, nil
+	return sel.Text(), true
 }
 
-func gethtmlattr(sel *goquery.Selection, attr, selector string) (string, error) {
-	if attr == "" {
-		return sel.Text(), nil
+// htmlval is the value a form control would submit.
+func htmlval(s *goquery.Selection) string {
+	switch goquery.NodeName(s) {
+	case "textarea":
+		return s.Text()
+	case "select":
+		opt := s.Find("option[selected]").First()
+		if opt.Length() == 0 {
+			opt = s.Find("option").First()
+		}
+		return htmlval(opt)
+	case "option":
+		if v, has := s.Attr("value"); has {
+			return v
+		}
+		return s.Text()
 	}
+	v, _ := s.Attr("value")
+	return v
+}
 
-	attr_exp, _ := regexp.Compile(PT_ATTR)
+func compileJsonSelector(selector string) (*jsonpath, error) {
+	return compileJsonPath(selector)
+}
 
-	if attr_exp.MatchString(attr) {
-		vt := attr_exp.FindStringSubmatch(attr)
-		res, has := sel.Attr(vt[1])
-		if !has {
-			return "", errors.New("Can't Find attribute: " + attr + " selector: " + selector)
+func parseJsonSelector(js *simplejson.Json, selector string) (*simplejson.Json, error) {
+	jp, err := compileJsonSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	res := simplejson.New()
+	res.SetPath(nil, jp.value(js.Interface()))
+	return res, nil
+}
+
+// jsonSelect runs the selector of n over data. A query that may select many
+// values gives a list; types holding a single value take its first element.
+func (n *pipeNode) jsonSelect(data interface{}) interface{} {
+	if n.json == nil {
+		return data
+	}
+	data = n.json.value(data)
+	if n.json.singular() {
+		return data
+	}
+	switch n.tp {
+	case PT_INT, PT_FLOAT, PT_BOOL, PT_TEXT, PT_STRING, PT_JSON_PARSE:
+		if nodes, _ := data.([]interface{}); len(nodes) > 0 {
+			return nodes[0]
 		}
-		return res, nil
-	} else if attr == "html" {
-		html := ""
-		sel.Each(func(idx int, s1 *goquery.Selection) {
-			str, _ := s1.Html()
-			html += str
-		})
-		return html, nil
-	} else if attr == "outhtml" {
-		html := ""
-		sel.Each(func(idx int, s1 *goquery.Selection) {
-			str, _ := goquery.OuterHtml(s1)
-			html += str
-		})
-		return html, nil
+		return nil
 	}
+	return data
+}
 
-	return sel.Text(), nil
+// jsonScalar converts one json value to the int, float, bool or string rule
+// type. APIs often send numbers and booleans as strings, those are parsed
+// like text of an html page, null gives the zero value.
+// jsonText is the text a regexp runs over: a scalar as a string, an object
+// or array as its json encoding.
+func jsonText(v interface{}) (string, error) {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		bs, err := json.Marshal(v)
+		return string(bs), err
+	}
+	text, err := jsonScalar(v, PT_STRING)
+	if err != nil {
+		return "", err
+	}
+	return text.(string), nil
 }
 
-func parseJsonSelector(js *simplejson.Json, selector string) (*simplejson.Json, error) {
-	subs := strings.Split(selector, ".")
-
-	for _, s := range subs {
-		if index := strings.Index(s, "["); index >= 0 {
-			if index > 0 {
-				k := s[:index]
-				if k != "this" {
-					js = js.Get(k)
-				}
-			}
-			s = s[index:]
-			exp, _ := regexp.Compile(`^\[(\d+)\]$`)
-			if !exp.MatchString(s) {
-				return nil, errors.New("parse json selector error:  " + selector)
-			}
-			v := exp.FindStringSubmatch(s)
-			int_v, err := strconv.Atoi(v[1])
-			if err != nil {
-				return nil, err
-			}
-			js = js.GetIndex(int_v)
-		} else {
-			if s == "this" {
-				continue
-			}
-			js = js.Get(s)
+func jsonScalar(v interface{}, tp string) (interface{}, error) {
+	switch tp {
+	case PT_TEXT, PT_STRING, PT_TEXT_ARRAY, PT_STRING_ARRAY:
+		switch x := v.(type) {
+		case nil:
+			return "", nil
+		case string:
+			return x, nil
+		case json.Number:
+			return x.String(), nil
+		case bool:
+			return strconv.FormatBool(x), nil
 		}
+		return nil, errors.New("json value is not a string")
+	}
+
+	switch x := v.(type) {
+	case nil:
+		return parseTextValue("0", tp)
+	case string:
+		return parseTextValue(strings.TrimSpace(x), tp)
 	}
-	return js, nil
+	js := simplejson.New()
+	js.SetPath(nil, v)
+	switch tp {
+	case PT_INT, PT_INT_ARRAY:
+		return js.Int64()
+	case PT_FLOAT, PT_FLOAT_ARRAY:
+		return js.Float64()
+	case PT_BOOL, PT_BOOL_ARRAY:
+		return js.Bool()
+	}
+	return nil, errors.New("unsupport json value type: " + tp)
 }
 
-func (p *PipeItem) pipeJson(body []byte) (interface{}, error) {
+// jsonScalarArray converts every element of a json array with jsonScalar, the
+// result has the same Go type as the html engine gives.
+func jsonScalarArray(vs []interface{}, tp string) (interface{}, error) {
+	var res reflect.Value
+	switch tp {
+	case PT_INT_ARRAY:
+		res = reflect.ValueOf(make([]int64, 0, len(vs)))
+	case PT_FLOAT_ARRAY:
+		res = reflect.ValueOf(make([]float64, 0, len(vs)))
+	case PT_BOOL_ARRAY:
+		res = reflect.ValueOf(make([]bool, 0, len(vs)))
+	default:
+		res = reflect.ValueOf(make([]string, 0, len(vs)))
+	}
 
+	for i, v := range vs {
+		x, err := jsonScalar(v, tp)
+		if err != nil {
+			return nil, errors.New("json array index " + strconv.Itoa(i) + ": " + err.Error())
+		}
+		res = reflect.Append(res, reflect.ValueOf(x))
+	}
+	return res.Interface(), nil
+}
+
+// decodeJson decodes body once for the whole rule tree; numbers are kept as
+// json.Number so large integers survive.
+func decodeJson(body []byte) (interface{}, error) {
 	js, err := simplejson.NewJson(body)
 	if err != nil {
 		return nil, err
 	}
+	return js.Interface(), nil
+}
+
+func (n *pipeNode) pipeJson(ctx *runContext, body []byte) (interface{}, error) {
+	if isConstType(n.tp) {
+		return n.pipeJsonValue(ctx, nil)
+	}
+	if n.exp != nil && n.json == nil {
+		// a bare regexp: selector reads the page as it is
+		return n.pipeText(ctx, body)
+	}
+	data, err := decodeJson(body)
+	if err != nil {
+		return nil, err
+	}
+	return n.pipeJsonValue(ctx, data)
+}
+
+// pipeJsonValue walks a decoded json tree, sub nodes get the selected part of
+// it as is.
+func (n *pipeNode) pipeJsonValue(ctx *runContext, data interface{}) (val interface{}, err error) {
+	defer n.settle(&val, &err)
+	if isConstType(n.tp) {
+		return n.filter(ctx, n.selector)
+	}
 
-	if p.Selector != "" {
-		js, err = parseJsonSelector(js, p.Selector)
+	if n.jsonErr != nil {
+		return nil, n.jsonErr
+	}
+
+	if n.exp != nil {
+		text, err := jsonText(n.jsonSelect(data))
 		if err != nil {
 			return nil, err
 		}
+		return n.parseRegexp(ctx, text)
 	}
 
-	switch p.Type {
-	case PT_INT:
-		return callFilter(js.MustInt64(0), p.Filter)
-	case PT_FLOAT:
-		return callFilter(js.MustFloat64(0.0), p.Filter)
-	case PT_BOOL:
-		return callFilter(js.MustBool(false), p.Filter)
-	case PT_TEXT, PT_STRING:
-		return callFilter(js.MustString(""), p.Filter)
-	case PT_TEXT_ARRAY, PT_STRING_ARRAY:
-		v, err := js.StringArray()
+	js := simplejson.New()
+	js.SetPath(nil, n.jsonSelect(data))
+	if js.Interface() == nil && n.missRule() {
+		return nil, notFound("Selector can't Find value!: " + n.selector)
+	}
+
+	switch n.tp {
+	case PT_INT, PT_FLOAT, PT_BOOL, PT_TEXT, PT_STRING:
+		v, err := jsonScalar(js.Interface(), n.tp)
+		if err != nil {
+			return nil, err
+		}
+		return n.filter(ctx, v)
+	case PT_INT_ARRAY, PT_FLOAT_ARRAY, PT_BOOL_ARRAY, PT_TEXT_ARRAY, PT_STRING_ARRAY:
+		vs, err := js.Array()
+		if err != nil {
+			return nil, err
+		}
+		v, err := jsonScalarArray(vs, n.tp)
 		if err != nil {
 			return nil, err
 		}
-		return callFilter(v, p.Filter)
+		return n.filter(ctx, v)
 	case PT_JSON_VALUE:
-		return callFilter(js.Interface(), p.Filter)
+		return n.filter(ctx, js.Interface())
 	case PT_JSON_PARSE:
-		if p.SubItem == nil || len(p.SubItem) <= 0 {
-			return nil, errors.New("Pipe type jsonparse need one subItem!")
-		}
 		body_str := strings.TrimSpace(js.MustString(""))
 		if body_str == "" {
 			return nil, nil
@@ -545,112 +1058,118 @@ func (p *PipeItem) pipeJson(body []byte) (interface{}, error) {
 		if err != nil {
 			return nil, errors.New("jsonparse: text is not a json string" + err.Error())
 		}
-		parse_item := p.SubItem[0]
-		res, err := parse_item.pipeJson(body)
+		res, err := n.subs[0].pipeJson(ctx, body)
 		if err != nil {
 			return nil, err
 		}
-		return callFilter(res, p.Filter)
+		return n.filter(ctx, res)
 	case PT_ARRAY:
 		v, err := js.Array()
 		if err != nil {
 			return nil, err
 		}
 
-		if p.SubItem == nil || len(p.SubItem) <= 0 {
-			return nil, errors.New("Pipe type array need one subItem!")
-		}
-		array_item := p.SubItem[0]
+		array_item := n.subs[0]
 		res := make([]interface{}, 0)
 		for _, r := range v {
-			data, _ := json.Marshal(r)
-			vl, _ := array_item.pipeJson(data)
+			vl, err := array_item.pipeJsonValue(ctx, r)
+			if err = ctx.collect(array_item, err); err != nil {
+				return nil, err
+			}
 			res = append(res, vl)
 		}
-		return callFilter(res, p.Filter)
+		return n.filter(ctx, res)
 	case PT_MAP:
-		if p.SubItem == nil || len(p.SubItem) <= 0 {
-			return nil, errors.New("Pipe type array need one subItem!")
-		}
-		data, _ := json.Marshal(js)
-		res := make(map[string]interface{})
-		for _, subitem := range p.SubItem {
-			if subitem.Name == "" {
-				continue
-			}
-			res[subitem.Name], _ = subitem.pipeJson(data)
+		res, err := n.pipeMap(ctx, func(sub *pipeNode) (interface{}, error) {
+			return sub.pipeJsonValue(ctx, js.Interface())
+		})
+		if err != nil {
+			return nil, err
 		}
-
-		return callFilter(res, p.Filter)
-	default:
-		return callFilter(0, p.Filter)
+		return n.filter(ctx, res)
 	}
 
-	return nil, nil
+	return nil, unsupportTypeError(n.path, n.tp, PAGE_JSON)
 }
 
-func (p *PipeItem) pipeText(body []byte) (interface{}, error) {
+func (n *pipeNode) pipeText(ctx *runContext, body []byte) (val interface{}, err error) {
+	defer n.settle(&val, &err)
+	if isConstType(n.tp) {
+		return n.filter(ctx, n.selector)
+	}
+
+	if n.textErr != nil {
+		return nil, n.textErr
+	}
+
 	body_str := string(body)
-	if strings.HasPrefix(p.Selector, "regexp:") {
-		return p.parseRegexp(body_str)
+	if n.exp != nil {
+		if n.text != nil {
+			body_str = strings.Join(n.text.split(body_str), "\n")
+		}
+		return n.parseRegexp(ctx, body_str)
+	}
+
+	if isArrayType(n.tp) {
+		return n.pipeTextParts(ctx, n.text.split(body_str))
+	}
+
+	// single value types take the first part
+	if n.text != nil {
+		parts := n.text.split(body_str)
+		if len(parts) == 0 {
+			return nil, notFound("Selector can't Find value!: " + n.selector)
+		}
+		body_str = parts[0]
+		body = []byte(body_str)
 	}
 
-	switch p.Type {
+	switch n.tp {
 	case PT_INT, PT_FLOAT, PT_BOOL:
-		val, err := parseTextValue(body_str, p.Type)
+		val, err := parseTextValue(body_str, n.tp)
 		if err != nil {
 			return nil, err
 		}
-		return callFilter(val, p.Filter)
+		return n.filter(ctx, val)
 	case PT_TEXT, PT_STRING:
-		return callFilter(body_str, p.Filter)
+		return n.filter(ctx, body_str)
 	case PT_JSON_PARSE:
-		if p.SubItem == nil || len(p.SubItem) <= 0 {
-			return nil, errors.New("Pipe type jsonparse need one subItem!")
-		}
 		body, err := text2jsonbyte(body_str)
 		if err != nil {
 			return nil, errors.New("jsonparse: text is not a json string" + err.Error())
 		}
-		parse_item := p.SubItem[0]
-		res, err := parse_item.pipeJson(body)
+		res, err := n.subs[0].pipeJson(ctx, body)
 		if err != nil {
 			return nil, err
 		}
-		return callFilter(res, p.Filter)
+		return n.filter(ctx, res)
 	case PT_JSON_VALUE:
 		res, err := text2json(string(body))
 		if err != nil {
 			return nil, err
 		}
-		return callFilter(res, p.Filter)
+		return n.filter(ctx, res)
 	case PT_MAP:
-		if p.SubItem == nil || len(p.SubItem) <= 0 {
-			return nil, errors.New("Pipe type array need one subItem!")
-		}
-		res := make(map[string]interface{})
-		for _, subitem := range p.SubItem {
-			if subitem.Name == "" {
-				continue
-			}
-			res[subitem.Name], _ = subitem.pipeText(body)
+		res, err := n.pipeMap(ctx, func(sub *pipeNode) (interface{}, error) {
+			return sub.pipeText(ctx, body)
+		})
+		if err != nil {
+			return nil, err
 		}
-		return callFilter(res, p.Filter)
-	default:
-		return callFilter(0, p.Filter)
+		return n.filter(ctx, res)
 	}
 
-	return nil, errors.New("Not support pipe type")
+	return nil, unsupportTypeError(n.path, n.tp, PAGE_TEXT)
 }
 
 func text2int(text interface{}) (interface{}, error) {
 	switch val := text.(type) {
 	case string:
-		return strconv.ParseInt(val, 10, 64)
+		return parseInteger(val, default_number_format)
 	case []string:
 		vs := make([]int64, 0)
 		for _, v := range val {
-			n, err := strconv.ParseInt(v, 10, 64)
+			n, err := parseInteger(v, default_number_format)
 			if err != nil {
 				return nil, err
 			}
@@ -664,11 +1183,12 @@ func text2int(text interface{}) (interface{}, error) {
 func text2float(text interface{}) (interface{}, error) {
 	switch val := text.(type) {
 	case string:
-		return strconv.ParseFloat(val, 64)
+		n, _, err := parseNumber(val, default_number_format)
+		return n, err
 	case []string:
 		vs := make([]float64, 0)
 		for _, v := range val {
-			n, err := strconv.ParseFloat(v, 64)
+			n, _, err := parseNumber(v, default_number_format)
 			if err != nil {
 				return nil, err
 			}
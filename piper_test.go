@@ -0,0 +1,107 @@
+package gopiper
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnknownType(t *testing.T) {
+	pipe := PipeItem{}
+	err := json.Unmarshal([]byte(`
+		{
+			"type": "map",
+			"subitem": [
+				{"name": "title", "type": "string", "selector": "title"},
+				{"name": "items", "type": "array", "selector": "li", "subitem": [
+					{"type": "strng"}
+				]}
+			]
+		}
+	`), &pipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pipe.PipeBytes([]byte(`<title>t</title><li>1</li>`), PAGE_HTML)
+	rerr, ok := err.(*RuleError)
+	if !ok {
+		t.Fatalf("expect RuleError, got %v", err)
+	}
+	if rerr.Path != "$.subitem[1].subitem[0].type" || rerr.Value != "strng" {
+		t.Fatalf("unexpected rule error: %v", rerr)
+	}
+
+	pipe = PipeItem{Type: PT_STRING}
+	_, err = pipe.PipeBytes([]byte(`{}`), "jsonp")
+	if rerr, ok := err.(*RuleError); !ok || rerr.Value != "jsonp" {
+		t.Fatalf("expect page type RuleError, got %v", err)
+	}
+}
+
+func TestUnsupportType(t *testing.T) {
+	pipe := PipeItem{Type: PT_HREF_ARRAY}
+	_, err := pipe.PipeBytes([]byte(`{}`), PAGE_JSON)
+	var rerr *RuleError
+	if !errors.As(err, &rerr) || rerr.Path != "$.type" {
+		t.Fatalf("expect RuleError for $.type, got %v", err)
+	}
+}
+
+func TestConstType(t *testing.T) {
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "source", Type: PT_CONST, Selector: "douban", Filter: "preadd(www.)"},
+		{Name: "now", Filter: "unixtime"},
+	}}
+	val, err := pipe.PipeBytes([]byte(`<html></html>`), PAGE_HTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := val.(map[string]interface{})
+	if res["source"] != "www.douban" {
+		t.Fatalf("unexpected const value: %v", res["source"])
+	}
+	if _, ok := res["now"].(int64); !ok {
+		t.Fatalf("unexpected unixtime value: %v", res["now"])
+	}
+}
+
+func TestJsonTypedArray(t *testing.T) {
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "ids", Type: PT_INT_ARRAY, Selector: "ids"},
+		{Name: "prices", Type: PT_FLOAT_ARRAY, Selector: "items[*].price"},
+		{Name: "flags", Type: PT_BOOL_ARRAY, Selector: "flags"},
+		{Name: "names", Type: PT_STRING_ARRAY, Selector: "names"},
+		{Name: "count", Type: PT_INT, Selector: "count"},
+	}}
+	body := `{
+		"ids": [1, "12", " 3 "],
+		"items": [{"price": 1.5}, {"price": "2.25"}],
+		"flags": [true, "false", "1"],
+		"names": ["a", 2, true],
+		"count": "42"
+	}`
+	val, err := pipe.PipeBytes([]byte(body), PAGE_JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"ids":    []int64{1, 12, 3},
+		"prices": []float64{1.5, 2.25},
+		"flags":  []bool{true, false, true},
+		"names":  []string{"a", "2", "true"},
+		"count":  int64(42),
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected typed array result: %#v", val)
+	}
+
+	pl := MustCompile(PipeItem{Type: PT_INT_ARRAY, Selector: "ids"})
+	_, _, err = pl.Extract([]byte(`{"ids": [1, "x"]}`), PAGE_JSON, Options{})
+	if err == nil || !strings.Contains(err.Error(), "index 1") {
+		t.Fatalf("expect conversion error at index 1, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package gopiper
+
+import (
+	"container/list"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// A regexp selector is `regexp:<expr>` or `regexpall:<expr>`, optionally with
+// flags between a slash and the colon, e.g. `regexp/is:<p>(.+?)</p>`:
+//
+//	i  case insensitive
+//	m  ^ and $ match at line boundaries
+//	s  . matches \n too
+//	U  ungreedy: swap meaning of x* and x*?
+var regexp_selector_exp = regexp.MustCompile(`^(regexp|regexpall)(?:/([^:]*))?:`)
+
+var regexp_suffix_exp = regexp.MustCompile(`\|regexp(?:all)?(?:/[^:]*)?:`)
+
+// splitRegexpSelector cuts a combined selector such as `#info@text|regexp:...`
+// into the node selector and the regexp selector that runs over the value of
+// the selected nodes. Either part may be empty.
+func splitRegexpSelector(selector string) (node, exp string) {
+	if regexp_selector_exp.MatchString(selector) {
+		return "", selector
+	}
+	if loc := regexp_suffix_exp.FindStringIndex(selector); loc != nil && loc[0] > 0 {
+		return selector[:loc[0]], selector[loc[0]+1:]
+	}
+	return selector, ""
+}
+
+// parseRegexpSelector returns the Go expression of a regexp selector and
+// whether it takes all matches. ok is false for other selectors.
+func parseRegexpSelector(selector string) (expr string, all bool, ok bool, err error) {
+	m := regexp_selector_exp.FindStringSubmatch(selector)
+	if m == nil {
+		return "", false, false, nil
+	}
+	expr = selector[len(m[0]):]
+	for _, flag := range m[2] {
+		if !strings.ContainsRune("imsU", flag) {
+			return "", false, true, errors.New("unknown regexp flag '" + string(flag) + "', expect one of i, m, s, U")
+		}
+	}
+	if m[2] != "" {
+		expr = "(?" + m[2] + ")" + expr
+	}
+	return expr, m[1] == "regexpall", true, nil
+}
+
+// regexpCache is a bounded LRU of compiled expressions, shared by every rule
+// so that the same expression in many rules, or a rule compiled again on
+// each PipeBytes call, is parsed once.
+type regexpCache struct {
+	mu    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List // front is the most recently used
+}
+
+type regexpEntry struct {
+	expr string
+	exp  *regexp.Regexp
+}
+
+func newRegexpCache(size int) *regexpCache {
+	return &regexpCache{size: size, items: make(map[string]*list.Element), order: list.New()}
+}
+
+var regexp_cache = newRegexpCache(512)
+
+// compileRegexp is regexp.Compile through the shared cache.
+func compileRegexp(expr string) (*regexp.Regexp, error) {
+	return regexp_cache.compile(expr)
+}
+
+func (c *regexpCache) compile(expr string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	if el, ok := c.items[expr]; ok {
+		c.order.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*regexpEntry).exp, nil
+	}
+	c.mu.Unlock()
+
+	// compile outside the lock, a second caller may do the same work
+	exp, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[expr]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*regexpEntry).exp, nil
+	}
+	c.items[expr] = c.order.PushFront(&regexpEntry{expr, exp})
+	for c.order.Len() > c.size {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.items, el.Value.(*regexpEntry).expr)
+	}
+	return exp, nil
+}
@@ -0,0 +1,200 @@
+package gopiper
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPriceHtml = `<html><body><ul>
+<li>apple <b>$1.5</b></li>
+<li>pear <b>$2.25</b></li>
+<li>plum <b>$3</b></li>
+</ul></body></html>`
+
+func TestRegexpAll(t *testing.T) {
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "prices", Type: PT_FLOAT_ARRAY, Selector: `regexpall:\$([\d.]+)`},
+		{Name: "first", Type: PT_FLOAT, Selector: `regexpall:\$([\d.]+)`},
+		{Name: "tags", Type: PT_STRING_ARRAY, Selector: `regexpall:<b>`},
+		{Name: "none", Type: PT_STRING_ARRAY, Selector: `regexpall:€(\d+)`},
+		{Name: "items", Type: PT_ARRAY, Selector: `regexpall:<li>\w+ <b>\$[\d.]+`, SubItem: []PipeItem{
+			{Type: PT_MAP, SubItem: []PipeItem{
+				{Name: "name", Type: PT_STRING, Selector: `regexp:<li>(\w+)`},
+				{Name: "price", Type: PT_FLOAT, Selector: `regexp:\$([\d.]+)`},
+			}},
+		}},
+	}}
+
+	expect := map[string]interface{}{
+		"prices": []float64{1.5, 2.25, 3},
+		"first":  1.5,
+		"tags":   []string{"<b>", "<b>", "<b>"},
+		"none":   []string{},
+		"items": []interface{}{
+			map[string]interface{}{"name": "apple", "price": 1.5},
+			map[string]interface{}{"name": "pear", "price": 2.25},
+			map[string]interface{}{"name": "plum", "price": 3.0},
+		},
+	}
+
+	for _, pagetype := range []string{PAGE_HTML, PAGE_TEXT} {
+		val, err := pipe.PipeBytes([]byte(testPriceHtml), pagetype)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(val, expect) {
+			t.Fatalf("%s: unexpected regexpall result: %#v", pagetype, val)
+		}
+	}
+}
+
+func TestRegexpNamedGroups(t *testing.T) {
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "first", Type: PT_MAP, Selector: `regexp:<li>(?P<name>\w+) <b>\$(?P<price>[\d.]+)`},
+		{Name: "table", Type: PT_ARRAY, Selector: `regexpall:<li>(?P<name>\w+) <b>\$(?P<price>[\d.]+)`},
+		{Name: "typed", Type: PT_MAP, Selector: `regexp:<li>(?P<name>\w+) <b>\$(?P<price>[\d.]+)`, SubItem: []PipeItem{
+			{Name: "price", Type: PT_FLOAT},
+			{Name: "tag", Type: PT_STRING, Selector: `regexp:<(\w+)>\$`},
+		}},
+	}}
+
+	expect := map[string]interface{}{
+		"first": map[string]interface{}{"name": "apple", "price": "1.5"},
+		"table": []interface{}{
+			map[string]interface{}{"name": "apple", "price": "1.5"},
+			map[string]interface{}{"name": "pear", "price": "2.25"},
+			map[string]interface{}{"name": "plum", "price": "3"},
+		},
+		"typed": map[string]interface{}{"name": "apple", "price": 1.5, "tag": "b"},
+	}
+
+	for _, pagetype := range []string{PAGE_HTML, PAGE_TEXT} {
+		val, err := pipe.PipeBytes([]byte(testPriceHtml), pagetype)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(val, expect) {
+			t.Fatalf("%s: unexpected named group result: %#v", pagetype, val)
+		}
+	}
+
+	_, err := Compile(PipeItem{Type: PT_MAP, Selector: `regexp:(\d+)`})
+	if rerr, ok := err.(*RuleError); !ok || rerr.Path != "$.subitem" {
+		t.Fatalf("expect subitem RuleError without named groups, got %v", err)
+	}
+
+	// json and js pages: a bare regexp reads the body, a nested one the json
+	// text of its value
+	groups := PipeItem{Type: PT_ARRAY, Selector: `regexpall:(?P<n>\d+)`}
+	digits := []interface{}{map[string]interface{}{"n": "1"}, map[string]interface{}{"n": "2"}}
+	for _, test := range []struct{ body, pagetype string }{
+		{`[1,2]`, PAGE_JSON},
+		{`var list = [1,2];`, PAGE_JS},
+	} {
+		val, err := groups.PipeBytes([]byte(test.body), test.pagetype)
+		if err != nil || !reflect.DeepEqual(val, digits) {
+			t.Fatalf("%s: unexpected bare regexp result: %#v %v", test.pagetype, val, err)
+		}
+	}
+	nested := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "list", Type: PT_ARRAY, Selector: `list|regexpall:(?P<n>\d+)`},
+	}}
+	for _, test := range []struct{ body, pagetype string }{
+		{`{"list": [1,2]}`, PAGE_JSON},
+		{`var list = [1,2];`, PAGE_JS},
+	} {
+		val, err := nested.PipeBytes([]byte(test.body), test.pagetype)
+		if err != nil || !reflect.DeepEqual(val, map[string]interface{}{"list": digits}) {
+			t.Fatalf("%s: unexpected nested regexp result: %#v %v", test.pagetype, val, err)
+		}
+	}
+}
+
+func TestRegexpFlags(t *testing.T) {
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "name", Type: PT_STRING, Selector: `regexp/i:<LI>(\w+)`},
+		{Name: "lines", Type: PT_STRING_ARRAY, Selector: `regexpall/m:^<li>(\w+)`},
+		{Name: "block", Type: PT_STRING, Selector: `regexp/s:<ul>(.+)</ul>`, Filter: "trimspace"},
+		{Name: "lazy", Type: PT_STRING, Selector: `regexp/U:<b>(.+)</b>`},
+	}}
+	val, err := pipe.PipeBytes([]byte(testPriceHtml), PAGE_TEXT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := val.(map[string]interface{})
+	if res["name"] != "apple" || res["lazy"] != "$1.5" {
+		t.Fatalf("unexpected flag result: %#v", res)
+	}
+	if !reflect.DeepEqual(res["lines"], []string{"apple", "pear", "plum"}) {
+		t.Fatalf("unexpected multiline result: %#v", res["lines"])
+	}
+	if !strings.HasPrefix(res["block"].(string), "<li>apple") {
+		t.Fatalf("unexpected dotall result: %#v", res["block"])
+	}
+
+	for _, selector := range []string{`regexp/x:a`, `regexpall:(`} {
+		_, err := Compile(PipeItem{Type: PT_MAP, SubItem: []PipeItem{{Name: "a", Type: PT_STRING, Selector: selector}}})
+		rerr, ok := err.(*RuleError)
+		if !ok || rerr.Path != "$.subitem[0].selector" || rerr.Value != selector {
+			t.Fatalf("%s: expect selector RuleError, got %v", selector, err)
+		}
+	}
+}
+
+func TestRegexpCache(t *testing.T) {
+	cache := newRegexpCache(2)
+	a, _ := cache.compile("a")
+	cache.compile("b")
+	if a2, _ := cache.compile("a"); a2 != a {
+		t.Fatal("expect cached regexp")
+	}
+	cache.compile("c") // evicts b, the least recently used
+	if _, ok := cache.items["b"]; ok || cache.order.Len() != 2 {
+		t.Fatalf("unexpected cache content: %v", cache.items)
+	}
+	if _, err := cache.compile("("); err == nil {
+		t.Fatal("expect compile error")
+	}
+}
+
+func TestRegexpCombined(t *testing.T) {
+	html := `<div id="info"><span>Year:</span> 2017<br/><a href="/subject/25850640/">link</a></div>`
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "year", Type: PT_INT, Selector: `#info//text|regexp:Year: (\d+)`},
+		{Name: "id", Type: PT_INT, Selector: `#info a//attr[href]|regexp:/subject/(\d+)/`},
+		{Name: "tag", Type: PT_STRING, Selector: `#info//outhtml|regexp:<(\w+) id=`},
+		{Name: "inner", Type: PT_STRING, Selector: `#info|regexp:^<(\w+)>`},
+		{Name: "none", Type: PT_STRING, Selector: `#none//text|regexp:(\d+)`},
+	}}
+	val, res, err := MustCompile(pipe).Extract([]byte(html), PAGE_HTML, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{"year": int64(2017), "id": int64(25850640), "tag": "div", "inner": "span", "none": nil}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected combined html result: %#v", val)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].Path != "$.subitem[4]" {
+		t.Fatalf("unexpected report: %v", res)
+	}
+
+	pipe = PipeItem{Type: PT_INT, Selector: `list[?@.name=='b'].title|regexp:No\.(\d+)`}
+	val, err = pipe.PipeBytes([]byte(`{"list": [{"name": "a", "title": "No.1"}, {"name": "b", "title": "No.2"}]}`), PAGE_JSON)
+	if err != nil || val != int64(2) {
+		t.Fatalf("unexpected combined json result: %#v %v", val, err)
+	}
+
+	pipe = PipeItem{Type: PT_INT, Selector: `lines|last|regexp:(\d+)`}
+	val, err = pipe.PipeBytes([]byte("a 1\nb 2\n"), PAGE_TEXT)
+	if err != nil || val != int64(2) {
+		t.Fatalf("unexpected combined text result: %#v %v", val, err)
+	}
+
+	pipe = PipeItem{Type: PT_STRING, Selector: `//item[2]/title|regexp:t(\w+)`}
+	val, err = pipe.PipeBytes([]byte(`<rss><item><title>one</title></item><item><title>two</title></item></rss>`), PAGE_XML)
+	if err != nil || val != "wo" {
+		t.Fatalf("unexpected combined xml result: %#v %v", val, err)
+	}
+}
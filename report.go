@@ -0,0 +1,260 @@
+package gopiper
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ExtractError is one failure met while running a pipeline: a selector that
+// found nothing, a value that did not convert to the rule type, a filter that
+// returned an error.
+type ExtractError struct {
+	Path     string // rule path, e.g. $.subitem[3]
+	Selector string
+	Filter   string // name of the failing filter, empty for selector errors
+	Err      error
+}
+
+func (e *ExtractError) Error() string {
+	if rerr, ok := e.Err.(*RuleError); ok {
+		return rerr.Error()
+	}
+	if e.Filter != "" {
+		return fmt.Sprintf("%s: filter %s: %v", e.Path, e.Filter, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *ExtractError) Unwrap() error {
+	return e.Err
+}
+
+// Report lists every failure of one extraction, in the order they happened.
+type Report struct {
+	Errors []*ExtractError
+}
+
+// OK reports whether the extraction had no failure at all.
+func (r *Report) OK() bool {
+	return len(r.Errors) == 0
+}
+
+func (r *Report) String() string {
+	lines := make([]string, 0, len(r.Errors))
+	for _, e := range r.Errors {
+		lines = append(lines, e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Options change how Extract runs a pipeline.
+type Options struct {
+	// Strict stops the extraction at the first failure and returns it,
+	// instead of leaving the failed field nil and going on.
+	Strict bool
+
+	// BaseURL is the URL of the page, overridden by an html <base href>. It
+	// resolves the urls of AbsUrl rules and of the absurl filter. Vars are
+	// values of the caller; both are handed to context filters, see
+	// FilterContext.
+	BaseURL string
+	Vars    map[string]interface{}
+
+	// Now is the time relative dates such as "3 hours ago" are read
+	// against, the start of the extraction when zero.
+	Now time.Time
+}
+
+// runContext is the state of one run, shared by every node of the tree.
+type runContext struct {
+	Options
+	report   *Report
+	pagetype string
+	base     *url.URL               // parsed BaseURL
+	parent   map[string]interface{} // the map being built, for FilterContext.Parent
+	edits    htmlEdits              // the copy of the html document rm edits
+}
+
+func newRunContext(opt Options) *runContext {
+	if opt.Now.IsZero() {
+		opt.Now = time.Now()
+	}
+	return &runContext{Options: opt, report: &Report{}}
+}
+
+func (ctx *runContext) fail(n *pipeNode, filter string, err error) *ExtractError {
+	if e, ok := err.(*ExtractError); ok {
+		return e
+	}
+	e := &ExtractError{Path: n.path, Selector: n.selector, Filter: filter, Err: err}
+	ctx.report.Errors = append(ctx.report.Errors, e)
+	return e
+}
+
+// collect records the error of sub node n. The error is given back only in
+// strict mode, where it has to stop the extraction.
+func (ctx *runContext) collect(n *pipeNode, err error) error {
+	if err == nil {
+		return nil
+	}
+	e := ctx.fail(n, "", err)
+	if ctx.Strict {
+		return e
+	}
+	if n.required {
+		// already in the report, the parent fails with it
+		return e
+	}
+	return nil
+}
+
+// missRule reports whether n says what to do when it finds nothing. The json
+// and regexp engines only treat an absent value as a miss for such nodes;
+// without a rule they keep returning the zero value of the type.
+func (n *pipeNode) missRule() bool {
+	return n.required || n.def != nil || n.omitempty
+}
+
+// settle replaces a miss by the node default, or drops it for omitempty
+// nodes. A required miss is always an error, so that collect fails the
+// parent. The failure of a sub node, already an ExtractError, is not a miss
+// of n.
+func (n *pipeNode) settle(val *interface{}, err *error) {
+	_, sub := (*err).(*ExtractError)
+	miss := (errors.Is(*err, ErrNotFound) && !sub) || (*err == nil && *val == nil)
+	if !miss {
+		return
+	}
+	switch {
+	case n.def != nil:
+		// a copy, the caller may change the result of one run
+		*val, *err = copyValue(n.def), nil
+	case n.required:
+		if *err == nil {
+			*err = notFound("required value is empty")
+		}
+	case n.omitempty:
+		*val, *err = nil, nil
+	}
+}
+
+// copyValue deep copies the maps, slices and pointers of v; other values are
+// returned as they are.
+func copyValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return copyReflect(reflect.ValueOf(v)).Interface()
+}
+
+func copyReflect(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(copyReflect(v.Elem()))
+		return c
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(copyReflect(v.Elem()))
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			c.SetMapIndex(k, copyReflect(v.MapIndex(k)))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyReflect(v.Index(i)))
+		}
+		return c
+	}
+	return v
+}
+
+// omit reports whether val is left out of the parent map.
+func (n *pipeNode) omit(val interface{}) bool {
+	if !n.omitempty {
+		return false
+	}
+	if val == nil {
+		return true
+	}
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+	return false
+}
+
+// pipeMap builds the result of a PT_MAP node, running every named sub item
+// with pipe. The map is the Parent of the filters run meanwhile.
+func (n *pipeNode) pipeMap(ctx *runContext, pipe func(sub *pipeNode) (interface{}, error)) (map[string]interface{}, error) {
+	res := make(map[string]interface{})
+	defer ctx.enterMap(res)()
+	for _, sub := range n.subs {
+		if sub.name == "" {
+			continue
+		}
+		v, err := pipe(sub)
+		if err = ctx.collect(sub, err); err != nil {
+			return nil, err
+		}
+		if sub.omit(v) {
+			continue
+		}
+		res[sub.name] = v
+	}
+	return res, nil
+}
+
+// enterMap makes res the parent map of the filters run until the returned
+// function restores the previous one.
+func (ctx *runContext) enterMap(res map[string]interface{}) func() {
+	parent := ctx.parent
+	ctx.parent = res
+	return func() {
+		ctx.parent = parent
+	}
+}
+
+// filter runs the filter chain of n over src, reporting failing filters.
+func (n *pipeNode) filter(ctx *runContext, src interface{}) (interface{}, error) {
+	if len(n.filters) == 0 {
+		return src, nil
+	}
+	fctx := &FilterContext{
+		BaseURL:  ctx.BaseURL,
+		PageType: ctx.pagetype,
+		Path:     n.path,
+		Rule:     n.rule,
+		Parent:   ctx.parent,
+		Vars:     ctx.Vars,
+		Now:      ctx.Now,
+	}
+	return n.filters.apply(fctx, src, func(name string, err error) error {
+		e := ctx.fail(n, name, err)
+		if ctx.Strict {
+			return e
+		}
+		return nil
+	})
+}
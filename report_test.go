@@ -0,0 +1,145 @@
+package gopiper
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestExtractReport(t *testing.T) {
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "title", Type: PT_STRING, Selector: "title"},
+		{Name: "missing", Type: PT_STRING, Selector: ".nosuchnode"},
+		{Name: "count", Type: PT_INT, Selector: "#count"},
+		{Name: "desc", Type: PT_STRING, Selector: "#desc", Filter: "sprintfmap(%s,a)|trimspace"},
+	}}
+	body := []byte(`<title>Title</title><p id="count">many</p><p id="desc"> text </p>`)
+
+	pl := MustCompile(pipe)
+	val, report, err := pl.Extract(body, PAGE_HTML, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := val.(map[string]interface{})
+	if res["title"] != "Title" || res["missing"] != nil || res["count"] != nil || res["desc"] != "text" {
+		t.Fatalf("unexpected partial result: %#v", res)
+	}
+
+	if len(report.Errors) != 3 {
+		t.Fatalf("expect 3 errors, got:\n%s", report)
+	}
+	expect := []struct{ path, selector, filter string }{
+		{"$.subitem[1]", ".nosuchnode", ""},
+		{"$.subitem[2]", "#count", ""},
+		{"$.subitem[3]", "#desc", "sprintfmap"},
+	}
+	for i, e := range expect {
+		got := report.Errors[i]
+		if got.Path != e.path || got.Selector != e.selector || got.Filter != e.filter || got.Err == nil {
+			t.Errorf("unexpected error %d: %#v", i, got)
+		}
+	}
+
+	_, report, err = pl.Extract(body, PAGE_HTML, Options{Strict: true})
+	e, ok := err.(*ExtractError)
+	if !ok || e.Path != "$.subitem[1]" {
+		t.Fatalf("expect strict mode to stop at $.subitem[1], got %v", err)
+	}
+	if len(report.Errors) != 1 {
+		t.Fatalf("expect 1 error in strict mode, got:\n%s", report)
+	}
+}
+
+func TestMissRules(t *testing.T) {
+	pipe := PipeItem{Type: PT_ARRAY, Selector: "li", SubItem: []PipeItem{
+		{Type: PT_MAP, SubItem: []PipeItem{
+			{Name: "name", Type: PT_STRING, Selector: "b", Required: true},
+			{Name: "price", Type: PT_FLOAT, Selector: "i", Default: 0.0},
+			{Name: "tag", Type: PT_STRING, Selector: "u", OmitEmpty: true},
+		}},
+	}}
+	body := []byte(`<ul><li><b>a</b><i>1.5</i><u>new</u></li><li><b>b</b><u></u></li><li><i>2</i></li></ul>`)
+
+	val, report, err := MustCompile(pipe).Extract(body, PAGE_HTML, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []interface{}{
+		map[string]interface{}{"name": "a", "price": 1.5, "tag": "new"},
+		map[string]interface{}{"name": "b", "price": 0.0},
+		nil,
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected result: %#v", val)
+	}
+
+	// the required miss fails the element holding it, reported once
+	if len(report.Errors) != 1 || report.Errors[0].Path != "$.subitem[0].subitem[0]" {
+		t.Fatalf("unexpected report:\n%s", report)
+	}
+
+	jpipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "id", Type: PT_INT, Selector: "id", Required: true},
+		{Name: "count", Type: PT_INT, Selector: "count", Default: 10},
+		{Name: "note", Type: PT_STRING, Selector: "note", OmitEmpty: true},
+		{Name: "legacy", Type: PT_STRING, Selector: "legacy"},
+	}}
+	val, report, err = MustCompile(jpipe).Extract([]byte(`{"id": 3}`), PAGE_JSON, Options{})
+	if err != nil || !report.OK() {
+		t.Fatal(err, report)
+	}
+	if !reflect.DeepEqual(val, map[string]interface{}{"id": int64(3), "count": int64(10), "legacy": ""}) {
+		t.Fatalf("unexpected json result: %#v", val)
+	}
+
+	_, report, err = MustCompile(jpipe).Extract([]byte(`{}`), PAGE_JSON, Options{})
+	if err == nil || len(report.Errors) != 1 {
+		t.Fatalf("expect required miss to fail the root map: %v\n%s", err, report)
+	}
+
+	// a scalar default has the rule type
+	var rule []PipeItem
+	if err := json.Unmarshal([]byte(`[
+		{"name": "int", "type": "int", "selector": "a", "default": 0},
+		{"name": "float", "type": "float", "selector": "b", "default": 1},
+		{"name": "bool", "type": "bool", "selector": "c", "default": "true"},
+		{"name": "text", "type": "string", "selector": "d", "default": 2}
+	]`), &rule); err != nil {
+		t.Fatal(err)
+	}
+	val, err = MustCompile(PipeItem{Type: PT_MAP, SubItem: rule}).RunBytes([]byte(`{}`), PAGE_JSON)
+	if err != nil || !reflect.DeepEqual(val, map[string]interface{}{"int": int64(0), "float": 1.0, "bool": true, "text": 2.0}) {
+		t.Fatalf("unexpected typed defaults: %#v %v", val, err)
+	}
+	if _, err := Compile(PipeItem{Type: PT_INT, Selector: "a", Default: "many"}); err == nil {
+		t.Fatal("expect error for a default that is not an int")
+	}
+
+	// every run gets its own copy of a map or slice default
+	tags := []interface{}{"none"}
+	dpipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "tags", Type: PT_STRING_ARRAY, Selector: "tags", Default: tags},
+		{Name: "meta", Type: PT_STRING, Selector: "meta", Default: map[string]interface{}{"tags": tags}},
+	}}
+	pl := MustCompile(dpipe)
+	tags[0] = "changed"
+	for i := 0; i < 2; i++ {
+		val, err := pl.RunBytes([]byte(`{}`), PAGE_JSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := map[string]interface{}{
+			"tags": []interface{}{"none"},
+			"meta": map[string]interface{}{"tags": []interface{}{"none"}},
+		}
+		if !reflect.DeepEqual(val, expect) {
+			t.Fatalf("run %d: default changed: %#v", i, val)
+		}
+		res := val.(map[string]interface{})
+		res["tags"].([]interface{})[0] = "mutated"
+		res["meta"].(map[string]interface{})["tags"].([]interface{})[0] = "mutated"
+		res["meta"].(map[string]interface{})["extra"] = 1
+	}
+}
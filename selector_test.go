@@ -41,10 +41,12 @@ func TestJsonPipe(t *testing.T) {
 					"subitem": [
 						{
 							"name": "now",
+							"type": "const",
 							"filter": "unixtime"
 						},
 						{
 							"name": "nowmill",
+							"type": "const",
 							"filter": "unixmill"
 						},
 						{
@@ -0,0 +1,121 @@
+package gopiper
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// textpipe is a text mode selector, it cuts the body into parts:
+//
+//	lines            every non blank line
+//	split:<sep>      the pieces between sep, `\t` and the like are unescaped
+//
+// Either may end with `|eq(n)`, `|first` or `|last` to keep one part, so a
+// csv line can be picked apart with `split:,|eq(2)`.
+type textpipe struct {
+	sep   string // empty for lines
+	index int
+	pick  bool
+}
+
+var text_pick_exp = regexp.MustCompile(`\|(?:eq\((-?\d+)\)|(first)|(last))$`)
+
+// compileTextSelector returns nil for selectors that are not text selectors;
+// text mode ignores those, as it always did.
+func compileTextSelector(selector string) (*textpipe, error) {
+	tp := &textpipe{}
+	if m := text_pick_exp.FindStringSubmatch(selector); m != nil {
+		selector = selector[:len(selector)-len(m[0])]
+		tp.pick = true
+		switch {
+		case m[2] != "":
+			tp.index = 0
+		case m[3] != "":
+			tp.index = -1
+		default:
+			tp.index, _ = strconv.Atoi(m[1])
+		}
+	}
+
+	switch {
+	case selector == "lines":
+	case strings.HasPrefix(selector, "split:"):
+		tp.sep = selector[6:]
+		if sep, err := strconv.Unquote(`"` + tp.sep + `"`); err == nil {
+			tp.sep = sep
+		}
+		if tp.sep == "" {
+			return nil, errors.New("split need a separator")
+		}
+	default:
+		return nil, nil
+	}
+	return tp, nil
+}
+
+// split cuts body into parts; a nil textpipe splits into lines, which is
+// what array types do in text mode without a selector.
+func (tp *textpipe) split(body string) []string {
+	var parts []string
+	if tp == nil || tp.sep == "" {
+		parts = make([]string, 0)
+		for _, line := range strings.Split(body, "\n") {
+			line = strings.TrimRight(line, "\r")
+			if strings.TrimSpace(line) != "" {
+				parts = append(parts, line)
+			}
+		}
+	} else {
+		parts = strings.Split(body, tp.sep)
+	}
+
+	if tp == nil || !tp.pick {
+		return parts
+	}
+	i := tp.index
+	if i < 0 {
+		i += len(parts)
+	}
+	if i < 0 || i >= len(parts) {
+		return []string{}
+	}
+	return parts[i : i+1]
+}
+
+func isArrayType(tp string) bool {
+	switch tp {
+	case PT_ARRAY, PT_INT_ARRAY, PT_FLOAT_ARRAY, PT_BOOL_ARRAY, PT_TEXT_ARRAY, PT_STRING_ARRAY:
+		return true
+	}
+	return false
+}
+
+// pipeTextParts runs the array types over the parts of a text body.
+func (n *pipeNode) pipeTextParts(ctx *runContext, parts []string) (interface{}, error) {
+	switch n.tp {
+	case PT_ARRAY:
+		array_item := n.subs[0]
+		res := make([]interface{}, 0)
+		for _, part := range parts {
+			v, err := array_item.pipeText(ctx, []byte(part))
+			if err = ctx.collect(array_item, err); err != nil {
+				return nil, err
+			}
+			res = append(res, v)
+		}
+		return n.filter(ctx, res)
+	case PT_INT_ARRAY, PT_FLOAT_ARRAY, PT_BOOL_ARRAY:
+		trimmed := make([]string, 0, len(parts))
+		for _, part := range parts {
+			trimmed = append(trimmed, strings.TrimSpace(part))
+		}
+		val, err := parseTextValue(trimmed, n.tp)
+		if err != nil {
+			return nil, err
+		}
+		return n.filter(ctx, val)
+	}
+	return n.filter(ctx, parts)
+}
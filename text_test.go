@@ -0,0 +1,85 @@
+package gopiper
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestTextArray(t *testing.T) {
+	pipe := PipeItem{}
+	err := json.Unmarshal([]byte(`
+		{
+			"type": "map",
+			"subitem": [
+				{
+					"name": "rows",
+					"type": "array",
+					"selector": "lines",
+					"subitem": [{
+						"type": "map",
+						"subitem": [
+							{"name": "id", "type": "int", "selector": "split:,|first"},
+							{"name": "name", "type": "string", "selector": "split:,|eq(1)"},
+							{"name": "tags", "type": "string", "selector": "split:,|last", "filter": "split(;)"}
+						]
+					}]
+				},
+				{"name": "header", "type": "string", "selector": "lines|first"}
+			]
+		}
+	`), &pipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := "id,name,tags\n1,foo,a;b\r\n\n 2 ,bar,c"
+	val, err := pipe.PipeBytes([]byte(body), PAGE_TEXT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := val.(map[string]interface{})
+	rows := res["rows"].([]interface{})
+	// the header row has no int id
+	if len(rows) != 3 || rows[0].(map[string]interface{})["id"] != nil {
+		t.Fatalf("unexpected rows: %#v", rows)
+	}
+	expect := map[string]interface{}{"id": int64(1), "name": "foo", "tags": []string{"a", "b"}}
+	if !reflect.DeepEqual(rows[1], expect) {
+		t.Fatalf("unexpected row: %#v", rows[1])
+	}
+	if res["header"] != "id,name,tags" {
+		t.Fatalf("unexpected header: %#v", res["header"])
+	}
+
+	pipe = PipeItem{Type: PT_ARRAY, Selector: `regexp:\d+ \w+`, SubItem: []PipeItem{
+		{Type: PT_MAP, SubItem: []PipeItem{
+			{Name: "n", Type: PT_INT, Selector: `regexp:(\d+)`},
+			{Name: "w", Type: PT_STRING, Selector: `split: |last`},
+		}},
+	}}
+	val, err = pipe.PipeBytes([]byte("1 one, 2 two"), PAGE_TEXT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches := []interface{}{
+		map[string]interface{}{"n": int64(1), "w": "one"},
+		map[string]interface{}{"n": int64(2), "w": "two"},
+	}
+	if !reflect.DeepEqual(val, matches) {
+		t.Fatalf("unexpected regexp array: %#v", val)
+	}
+
+	pipe = PipeItem{Type: PT_INT_ARRAY}
+	val, err = pipe.PipeBytes([]byte("1\n 2\n3\n"), PAGE_TEXT)
+	if err != nil || !reflect.DeepEqual(val, []int64{1, 2, 3}) {
+		t.Fatalf("unexpected int array: %#v %v", val, err)
+	}
+
+	pipe = PipeItem{Type: PT_STRING_ARRAY, Selector: `split:\t`}
+	val, err = pipe.PipeBytes([]byte("x\ty"), PAGE_TEXT)
+	if err != nil || !reflect.DeepEqual(val, []string{"x", "y"}) {
+		t.Fatalf("unexpected split: %#v %v", val, err)
+	}
+}
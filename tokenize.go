@@ -0,0 +1,255 @@
+package gopiper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pipecall is one `name` or `name(params)` step of an html selector pipeline
+// such as `li|eq(1)` or of a filter chain such as `trimspace|replace(a,b)`.
+// params is the text between the parentheses as written, quotes included.
+type pipecall struct {
+	name   string
+	params string
+	col    int // column of name, from 1
+}
+
+// splitPipeHead cuts the css selector in front of the first top-level `|` of
+// an html selector; a `|` in quotes, brackets or parentheses is css, as in
+// `[lang|=en]` or `:contains("a|b")`. rest starts after the `|`.
+func splitPipeHead(src string) (head, rest string, ok bool) {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '\'' || c == '"') && quoteStart(src, 0, i, "(,=["):
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			if depth > 0 {
+				depth--
+			}
+		case depth == 0 && c == '|':
+			return src[:i], src[i+1:], true
+		}
+	}
+	return src, "", false
+}
+
+// parsePipeCalls tokenizes `name(params)|name|...`. The parameters end at the
+// first `)` outside quotes that is followed by `|` or the end of the input,
+// so `replace(()|replace())`, `replace((豆瓣))`, `split(|)` and
+// `replace(http://a,b)` need no quoting. A quote, ' or ", only opens at the
+// start of an argument, so `replace(Don't,Do)` needs none either; in quotes
+// a backslash escapes the next character. offset is the column of src in the text the
+// error messages refer to.
+func parsePipeCalls(src string, offset int) ([]pipecall, error) {
+	calls := make([]pipecall, 0)
+	pos := 0
+	errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("column %d: %s", offset+pos+1, fmt.Sprintf(format, args...))
+	}
+	skipSpace := func() {
+		for pos < len(src) && (src[pos] == ' ' || src[pos] == '\t' || src[pos] == '\n' || src[pos] == '\r') {
+			pos++
+		}
+	}
+
+	for {
+		skipSpace()
+		start := pos
+		for pos < len(src) && isPipeNameChar(src[pos]) {
+			pos++
+		}
+		if pos == start {
+			if pos == len(src) {
+				return nil, errorf("expect a function name after '|'")
+			}
+			return nil, errorf("unexpected %q, expect a function name", src[pos])
+		}
+		call := pipecall{name: src[start:pos], col: offset + start + 1}
+
+		skipSpace()
+		if pos < len(src) && src[pos] == '(' {
+			open := pos
+			end, err := scanPipeParams(src, pos+1)
+			if err != nil {
+				pos = open
+				return nil, errorf("%s of %s(", err.Error(), call.name)
+			}
+			call.params = src[open+1 : end]
+			pos = end + 1
+			skipSpace()
+		}
+		calls = append(calls, call)
+
+		if pos == len(src) {
+			return calls, nil
+		}
+		if src[pos] != '|' {
+			return nil, errorf("unexpected %q after %s, expect '|'", src[pos], call.name)
+		}
+		pos++
+	}
+}
+
+// scanPipeParams returns the index of the `)` that closes the parameters
+// starting at pos.
+func scanPipeParams(src string, pos int) (int, error) {
+	var quote byte
+	for i := pos; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '\'' || c == '"') && quoteStart(src, pos, i, ","):
+			quote = c
+		case c == ')':
+			rest := strings.TrimLeft(src[i+1:], " \t\r\n")
+			if rest == "" || rest[0] == '|' {
+				return i, nil
+			}
+		}
+	}
+	if quote != 0 {
+		return 0, fmt.Errorf("unterminated %c", quote)
+	}
+	return 0, fmt.Errorf("missing )")
+}
+
+// quoteStart tells whether the quote at src[i] opens a quoted string: only
+// at the start of an argument, that is at from or after one of delims, spaces
+// aside. A quote inside a word, as in Don't or it's, is a plain character.
+func quoteStart(src string, from, i int, delims string) bool {
+	for i > from && (src[i-1] == ' ' || src[i-1] == '\t' || src[i-1] == '\n' || src[i-1] == '\r') {
+		i--
+	}
+	return i == from || strings.IndexByte(delims, src[i-1]) >= 0
+}
+
+func isPipeNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// unquoteParams returns the parameters without their quotes when they are a
+// single quoted string such as `'|'` or `"a,b"`, and as written otherwise.
+func unquoteParams(params string) string {
+	if s, ok := unquoteArg(strings.TrimSpace(params)); ok {
+		return s
+	}
+	return params
+}
+
+// unquoteArg resolves the escapes of s when it is exactly one quoted string.
+func unquoteArg(s string) (string, bool) {
+	if len(s) < 2 || (s[0] != '\'' && s[0] != '"') || s[len(s)-1] != s[0] {
+		return "", false
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		c := s[i]
+		if c == s[0] {
+			// a second quoted string, e.g. 'a','b'
+			return "", false
+		}
+		if c == '\\' && i+1 < len(s)-1 {
+			i++
+			switch s[i] {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case 'r':
+				c = '\r'
+			default:
+				c = s[i]
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), true
+}
+
+// parseFilterArgs splits filter parameters at the commas outside quotes and
+// types every argument, see FilterArg. col is the column of the `(`.
+func parseFilterArgs(params string, col int) ([]FilterArg, error) {
+	args := make([]FilterArg, 0)
+	if params == "" {
+		return args, nil
+	}
+
+	var quote byte
+	start := 0
+	for i := 0; i <= len(params); i++ {
+		if i < len(params) {
+			c := params[i]
+			switch {
+			case quote != 0:
+				if c == '\\' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+				continue
+			case (c == '\'' || c == '"') && quoteStart(params, 0, i, ","):
+				quote = c
+				continue
+			case c != ',':
+				continue
+			}
+		}
+
+		raw := params[start:i]
+		arg, err := parseFilterArg(raw)
+		if err != nil {
+			lead := len(raw) - len(strings.TrimLeft(raw, " \t\r\n"))
+			return nil, fmt.Errorf("column %d: %s", col+start+lead+1, err.Error())
+		}
+		args = append(args, arg)
+		start = i + 1
+	}
+	return args, nil
+}
+
+func parseFilterArg(raw string) (FilterArg, error) {
+	arg := FilterArg{Raw: raw}
+	s := strings.TrimSpace(raw)
+	if s != "" && (s[0] == '\'' || s[0] == '"') {
+		v, ok := unquoteArg(s)
+		if !ok {
+			return arg, fmt.Errorf("unexpected text after the quoted argument %s", s)
+		}
+		arg.Value, arg.Quoted = v, true
+		return arg, nil
+	}
+
+	if s == "" {
+		// replace( ,-) replaces a space, as it did before arguments were typed
+		arg.Value = raw
+		return arg, nil
+	}
+	arg.Value = s
+	if s == "true" || s == "false" {
+		arg.Value = s == "true"
+	} else if strings.ContainsRune("+-.0123456789", rune(s[0])) {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			arg.Value = i
+		} else if f, err := strconv.ParseFloat(s, 64); err == nil {
+			arg.Value = f
+		}
+	}
+	return arg, nil
+}
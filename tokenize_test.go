@@ -0,0 +1,107 @@
+package gopiper
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPipeCalls(t *testing.T) {
+	tests := []struct {
+		src   string
+		calls []pipecall
+	}{
+		{"trimspace", []pipecall{{"trimspace", "", 1}}},
+		{"trimspace | intval", []pipecall{{"trimspace", "", 1}, {"intval", "", 13}}},
+		{"replace(()|replace())|intval", []pipecall{{"replace", "(", 1}, {"replace", ")", 12}, {"intval", "", 23}}},
+		{"trimspace|replace((豆瓣))|trim( )", []pipecall{{"trimspace", "", 1}, {"replace", "(豆瓣)", 11}, {"trim", " ", 29}}},
+		{"preadd(AAAA)|split(|)|join(,)", []pipecall{{"preadd", "AAAA", 1}, {"split", "|", 14}, {"join", ",", 23}}},
+		{"replace(http://a,b)", []pipecall{{"replace", "http://a,b", 1}}},
+		{`split(')|')|join("\")")`, []pipecall{{"split", "')|'", 1}, {"join", `"\")"`, 13}}},
+		// a quote inside a word does not open a string
+		{"replace(Don't,Do)", []pipecall{{"replace", "Don't,Do", 1}}},
+		{"contains(it's)|first", []pipecall{{"contains", "it's", 1}, {"first", "", 16}}},
+		{`replace(a, "it's)")`, []pipecall{{"replace", `a, "it's)"`, 1}}},
+	}
+	for _, test := range tests {
+		calls, err := parsePipeCalls(test.src, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		if !reflect.DeepEqual(calls, test.calls) {
+			t.Fatalf("%s: unexpected calls %#v", test.src, calls)
+		}
+	}
+
+	errs := map[string]string{
+		"trimspace|":        "column 11:",
+		"trimspace||intval": "column 11:",
+		"replace(a":         "column 8: missing )",
+		"split('|)":         "column 6: unterminated '",
+		"trim()x":           "column 5: missing )",
+		"trim x":            "column 6:",
+	}
+	for src, msg := range errs {
+		_, err := parsePipeCalls(src, 0)
+		if err == nil || !strings.HasPrefix(err.Error(), msg) {
+			t.Fatalf("%s: expect error %q, got %v", src, msg, err)
+		}
+	}
+}
+
+func TestPipeUnquote(t *testing.T) {
+	tests := map[string]string{
+		"a,b":       "a,b",
+		"'|'":       "|",
+		`"a,b"`:     "a,b",
+		`'it\'s'`:   "it's",
+		`"a\tb"`:    "a\tb",
+		`'a','b'`:   `'a','b'`,
+		`'a`:        `'a`,
+		`\d+`:       `\d+`,
+		`  ' x '  `: " x ",
+	}
+	for params, expect := range tests {
+		if v := unquoteParams(params); v != expect {
+			t.Fatalf("%s: unexpected %q", params, v)
+		}
+	}
+}
+
+func TestPipeTokenizer(t *testing.T) {
+	html := `<html><head><title>看不见的客人 (豆瓣)</title></head><body>
+	<span class="year">(2016)</span>
+	<ul><li lang="en-US">a|b</li><li lang="zh">c)d</li></ul>
+	</body></html>`
+
+	pipe := PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "name", Type: PT_STRING, Selector: "title", Filter: "trimspace|replace((豆瓣))|trim( )"},
+		{Name: "year", Type: PT_STRING, Selector: "span.year", Filter: "replace(()|replace())|intval"},
+		{Name: "url", Type: PT_STRING, Selector: "li|eq(0)", Filter: "replace(a,http://a)"},
+		{Name: "pipe", Type: PT_STRING, Selector: "li[lang|=en]", Filter: "split('|')|join(,)"},
+		{Name: "paren", Type: PT_STRING, Selector: "li|contains(')')"},
+	}}
+	val, err := pipe.PipeBytes([]byte(html), PAGE_HTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"name":  "看不见的客人",
+		"year":  2016,
+		"url":   "http://a|b",
+		"pipe":  "a,b",
+		"paren": "c)d",
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected tokenizer result: %#v", val)
+	}
+
+	_, err = compileHtmlSelector("li|eq(1)|nth(2)")
+	if err == nil || !strings.Contains(err.Error(), "column 10") {
+		t.Fatalf("expect selector column error, got %v", err)
+	}
+	_, err = Compile(PipeItem{Type: PT_STRING, Selector: "li", Filter: "trimspace|replace(a"})
+	if rerr, ok := err.(*RuleError); !ok || rerr.Path != "$.filter" || !strings.Contains(rerr.Msg, "column 18") {
+		t.Fatalf("expect filter column error, got %v", err)
+	}
+}
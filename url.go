@@ -0,0 +1,100 @@
+package gopiper
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// resolveURL makes ref absolute against base. A ref that does not parse is
+// kept as it is.
+func resolveURL(base *url.URL, ref string) string {
+	if base == nil {
+		return ref
+	}
+	trimmed := strings.TrimSpace(ref)
+	if trimmed == "" {
+		return ref
+	}
+	u, err := url.Parse(trimmed)
+	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
+
+// setBase makes raw, resolved against the current base, the base URL of the
+// run.
+func (ctx *runContext) setBase(raw string) error {
+	if raw = strings.TrimSpace(raw); raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("invalid base url: " + err.Error())
+	}
+	if ctx.base != nil {
+		u = ctx.base.ResolveReference(u)
+	}
+	ctx.base = u
+	ctx.BaseURL = u.String()
+	return nil
+}
+
+// setDocumentBase honours the <base href> of an html document.
+func (ctx *runContext) setDocumentBase(doc *goquery.Document) {
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		// a broken <base> is ignored, as browsers do
+		ctx.setBase(href)
+	}
+}
+
+// absolute resolves the URLs extracted by an AbsUrl rule against the base URL
+// of the page.
+func (n *pipeNode) absolute(ctx *runContext, v interface{}) interface{} {
+	if !n.absurl || ctx.base == nil {
+		return v
+	}
+	switch vt := v.(type) {
+	case string:
+		return resolveURL(ctx.base, vt)
+	case []string:
+		res := make([]string, len(vt))
+		for i, ref := range vt {
+			res[i] = resolveURL(ctx.base, ref)
+		}
+		return res
+	}
+	return v
+}
+
+// absurl resolves a url, or each url of a list, against the base URL of the
+// page, or against its argument: `absurl` or `absurl(https://example.com/)`.
+func absurl(ctx *FilterContext, src *reflect.Value, args []FilterArg) (interface{}, error) {
+	raw := ctx.BaseURL
+	if len(args) > 0 {
+		raw = args[0].String()
+	}
+	if raw == "" {
+		return src.Interface(), errors.New("absurl needs a base url")
+	}
+	base, err := url.Parse(raw)
+	if err != nil {
+		return src.Interface(), errors.New("invalid base url: " + err.Error())
+	}
+
+	switch vt := src.Interface().(type) {
+	case string:
+		return resolveURL(base, vt), nil
+	case []string:
+		res := make([]string, len(vt))
+		for i, ref := range vt {
+			res[i] = resolveURL(base, ref)
+		}
+		return res, nil
+	}
+	return src.Interface(), nil
+}
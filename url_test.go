@@ -0,0 +1,84 @@
+package gopiper
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+const testLinkHtml = `<html><head>%s</head><body>
+<a class="rel" href="/subject/1/">one</a>
+<a class="cdn" href="//cdn.example.com/a.css">cdn</a>
+<a class="dot" href="../up.html">up</a>
+<a class="abs" href="https://other.example.org/x">other</a>
+<img src="img/p.jpg" data-src="img/lazy.jpg"/>
+</body></html>`
+
+func testLinkPipe() PipeItem {
+	return PipeItem{Type: PT_MAP, SubItem: []PipeItem{
+		{Name: "rel", Type: PT_HREF, Selector: "a.rel", AbsUrl: true},
+		{Name: "links", Type: PT_HREF_ARRAY, Selector: "a", AbsUrl: true},
+		{Name: "img", Type: PT_IMG_SRC, Selector: "img", AbsUrl: true},
+		{Name: "lazy", Type: "attr[data-src]", Selector: "img", AbsUrl: true},
+		{Name: "suffix", Type: PT_STRING, Selector: "a.dot@attr[href]", AbsUrl: true},
+		{Name: "raw", Type: PT_HREF, Selector: "a.rel"},
+		{Name: "filter", Type: PT_STRING, Selector: "img@attr[src]", Filter: "absurl"},
+		{Name: "given", Type: PT_STRING, Selector: "img@attr[src]", Filter: "absurl(https://static.example.net/)"},
+	}}
+}
+
+func TestAbsUrl(t *testing.T) {
+	pipe := testLinkPipe()
+	body := []byte(fmt.Sprintf(testLinkHtml, ""))
+	val, err := pipe.PipeBytesURL(body, PAGE_HTML, "http://movie.example.com/subject/2/page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"rel": "http://movie.example.com/subject/1/",
+		"links": []string{
+			"http://movie.example.com/subject/1/",
+			"http://cdn.example.com/a.css",
+			"http://movie.example.com/subject/up.html",
+			"https://other.example.org/x",
+		},
+		"img":    "http://movie.example.com/subject/2/img/p.jpg",
+		"lazy":   "http://movie.example.com/subject/2/img/lazy.jpg",
+		"suffix": "http://movie.example.com/subject/up.html",
+		"raw":    "/subject/1/",
+		"filter": "http://movie.example.com/subject/2/img/p.jpg",
+		"given":  "https://static.example.net/img/p.jpg",
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected absurl result: %#v", val)
+	}
+
+	// <base href> wins over the page url, and is relative to it
+	body = []byte(fmt.Sprintf(testLinkHtml, `<base href="/static/">`))
+	val, err = pipe.PipeBytesURL(body, PAGE_HTML, "https://movie.example.com/subject/2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := val.(map[string]interface{})
+	if res["img"] != "https://movie.example.com/static/img/p.jpg" || res["filter"] != res["img"] {
+		t.Fatalf("unexpected <base> result: %#v", res)
+	}
+
+	// without a base url the values are kept, absurl fails and is skipped
+	val, report, err := MustCompile(pipe).Extract([]byte(fmt.Sprintf(testLinkHtml, "")), PAGE_HTML, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = val.(map[string]interface{})
+	if res["rel"] != "/subject/1/" || res["filter"] != "img/p.jpg" || len(report.Errors) != 1 || report.Errors[0].Filter != "absurl" {
+		t.Fatalf("unexpected result without base: %#v %v", res, report)
+	}
+
+	_, err = Compile(PipeItem{Type: PT_INT, Selector: "a", AbsUrl: true})
+	if rerr, ok := err.(*RuleError); !ok || rerr.Path != "$.absurl" {
+		t.Fatalf("expect absurl RuleError, got %v", err)
+	}
+	if _, err = pipe.PipeBytesURL(body, PAGE_HTML, "http://a b/%zz"); err == nil {
+		t.Fatal("expect invalid base url error")
+	}
+}
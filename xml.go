@@ -0,0 +1,184 @@
+package gopiper
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+)
+
+// xmlselector is the xml counterpart of htmlselector: the node set matched by
+// an XPath selector. XPath can address attributes itself (`item/link/@href`),
+// so there is no `//attr` suffix in xml mode.
+type xmlselector struct {
+	nodes    []*xmlquery.Node
+	selector string
+}
+
+func parseXmlDocument(body []byte) (*xmlquery.Node, error) {
+	return xmlquery.Parse(bytes.NewReader(body))
+}
+
+func parseXmlSelector(nodes []*xmlquery.Node, selector string, expr *xpath.Expr) xmlselector {
+	res := make([]*xmlquery.Node, 0)
+	for _, n := range nodes {
+		res = append(res, xmlquery.QuerySelectorAll(n, expr)...)
+	}
+	return xmlselector{res, selector}
+}
+
+func (sel xmlselector) Size() int {
+	return len(sel.nodes)
+}
+
+func (sel xmlselector) Text() string {
+	text := ""
+	for _, n := range sel.nodes {
+		text += n.InnerText()
+	}
+	return text
+}
+
+func (sel xmlselector) Xml(self bool) string {
+	xml := ""
+	for _, n := range sel.nodes {
+		xml += n.OutputXML(self)
+	}
+	return xml
+}
+
+// Attr returns the attribute of the first node, like goquery's Selection.Attr.
+func (sel xmlselector) Attr(name string) (string, bool) {
+	if len(sel.nodes) == 0 {
+		return "", false
+	}
+	return xmlattr(sel.nodes[0], name)
+}
+
+// xmlattr looks an attribute up by its qualified name (`xml:lang`) or, when
+// the name has no prefix, by its local name alone.
+func xmlattr(n *xmlquery.Node, name string) (string, bool) {
+	if n.Type == xmlquery.AttributeNode {
+		if n.Data == name {
+			return n.InnerText(), true
+		}
+		return "", false
+	}
+	for _, attr := range n.Attr {
+		qname := attr.Name.Local
+		if attr.Name.Space != "" {
+			qname = attr.Name.Space + ":" + attr.Name.Local
+		}
+		if qname == name || (!strings.Contains(name, ":") && attr.Name.Local == name) {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+func (n *pipeNode) pipeXml(ctx *runContext, nodes []*xmlquery.Node) (val interface{}, err error) {
+	defer n.settle(&val, &err)
+
+	var sel = xmlselector{nodes, n.selector}
+
+	if isConstType(n.tp) {
+		return n.filter(ctx, n.selector)
+	}
+
+	if n.xpathErr != nil {
+		return nil, n.xpathErr
+	}
+
+	if n.exp != nil {
+		if n.xpath != nil {
+			sel = parseXmlSelector(nodes, n.selector, n.xpath)
+			if sel.Size() == 0 {
+				return nil, notFound("Selector can't Find node!: " + n.selector)
+			}
+		}
+		return n.parseRegexp(ctx, sel.Xml(false))
+	}
+
+	if n.xpath != nil {
+		sel = parseXmlSelector(nodes, n.selector, n.xpath)
+	}
+
+	if sel.Size() == 0 {
+		return nil, notFound("Selector can't Find node!: " + n.selector)
+	}
+
+	if n.attr != "" {
+		res, has := sel.Attr(n.attr)
+		if !has {
+			return nil, notFound("Can't Find attribute: " + n.tp + " selector: " + n.selector)
+		}
+		return n.filter(ctx, res)
+	} else if n.attrArray != "" {
+		res := make([]string, 0)
+		for _, node := range sel.nodes {
+			if v, has := xmlattr(node, n.attrArray); has {
+				res = append(res, v)
+			}
+		}
+		return n.filter(ctx, res)
+	}
+
+	switch n.tp {
+	case PT_INT, PT_FLOAT, PT_BOOL, PT_STRING, PT_TEXT:
+		val, err := parseTextValue(sel.Text(), n.tp)
+		if err != nil {
+			return nil, err
+		}
+		return n.filter(ctx, val)
+	case PT_INT_ARRAY, PT_FLOAT_ARRAY, PT_BOOL_ARRAY, PT_STRING_ARRAY, PT_TEXT_ARRAY:
+		res := make([]string, 0)
+		for _, node := range sel.nodes {
+			res = append(res, node.InnerText())
+		}
+		val, err := parseTextValue(res, n.tp)
+		if err != nil {
+			return nil, err
+		}
+		return n.filter(ctx, val)
+	case PT_HTML:
+		return n.filter(ctx, sel.Xml(false))
+	case PT_OUT_HTML:
+		return n.filter(ctx, sel.Xml(true))
+	case PT_HREF, PT_IMG_SRC, PT_IMG_ALT:
+		res, has := sel.Attr(n.tp)
+		if !has {
+			return nil, notFound("Can't Find attribute: " + n.tp + " selector: " + n.selector)
+		}
+		return n.filter(ctx, res)
+	case PT_HREF_ARRAY:
+		res := make([]string, 0)
+		for _, node := range sel.nodes {
+			if href, has := xmlattr(node, "href"); has {
+				res = append(res, href)
+			}
+		}
+		return n.filter(ctx, res)
+	case PT_ARRAY:
+		array_item := n.subs[0]
+		res := make([]interface{}, 0)
+		for _, node := range sel.nodes {
+			v, err := array_item.pipeXml(ctx, []*xmlquery.Node{node})
+			if err = ctx.collect(array_item, err); err != nil {
+				return nil, err
+			}
+			res = append(res, v)
+		}
+		return n.filter(ctx, res)
+	case PT_MAP:
+		res, err := n.pipeMap(ctx, func(sub *pipeNode) (interface{}, error) {
+			return sub.pipeXml(ctx, sel.nodes)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return n.filter(ctx, res)
+	}
+
+	return nil, unsupportTypeError(n.path, n.tp, PAGE_XML)
+}
@@ -0,0 +1,88 @@
+package gopiper
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const testRss = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+	<title>Feed</title>
+	<atom:link href="http://example.com/rss" rel="self"/>
+	<item>
+		<title><![CDATA[First <b>post</b>]]></title>
+		<link>http://example.com/1</link>
+		<dc:creator>alice</dc:creator>
+		<enclosure url="http://example.com/1.mp3" length="100"/>
+	</item>
+	<item>
+		<title>Second</title>
+		<link>http://example.com/2</link>
+		<dc:creator>bob</dc:creator>
+		<enclosure url="http://example.com/2.mp3" length="200"/>
+	</item>
+</channel>
+</rss>`
+
+func TestXmlPipe(t *testing.T) {
+	pipe := PipeItem{}
+	err := json.Unmarshal([]byte(`
+		{
+			"type": "map",
+			"subitem": [
+				{"name": "title", "type": "string", "selector": "/rss/channel/title"},
+				{"name": "self", "type": "attr[href]", "selector": "//atom:link"},
+				{"name": "lengths", "type": "int-array", "selector": "//item/enclosure/@length"},
+				{
+					"name": "items",
+					"type": "array",
+					"selector": "//item",
+					"subitem": [
+						{
+							"type": "map",
+							"subitem": [
+								{"name": "title", "type": "text", "selector": "title"},
+								{"name": "link", "type": "string", "selector": "link"},
+								{"name": "author", "type": "string", "selector": "dc:creator"},
+								{"name": "media", "type": "attr[url]", "selector": "enclosure"}
+							]
+						}
+					]
+				}
+			]
+		}
+	`), &pipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := pipe.PipeBytes([]byte(testRss), PAGE_XML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"title":   "Feed",
+		"self":    "http://example.com/rss",
+		"lengths": []int64{100, 200},
+		"items": []interface{}{
+			map[string]interface{}{
+				"title":  "First <b>post</b>",
+				"link":   "http://example.com/1",
+				"author": "alice",
+				"media":  "http://example.com/1.mp3",
+			},
+			map[string]interface{}{
+				"title":  "Second",
+				"link":   "http://example.com/2",
+				"author": "bob",
+				"media":  "http://example.com/2.mp3",
+			},
+		},
+	}
+	if !reflect.DeepEqual(val, expect) {
+		t.Fatalf("unexpected xml result: %#v", val)
+	}
+}